@@ -2,7 +2,6 @@ package machine
 
 import (
 	"go/ast"
-	"reflect"
 
 	"github.com/go-errors/errors"
 	"github.com/podocarp/goscript/types"
@@ -23,6 +22,22 @@ func (m *Machine) AddBuiltinsToContext() {
 			fun:      Append,
 			evalArgs: true,
 		},
+		"cap": {
+			fun:      Cap,
+			evalArgs: true,
+		},
+		"close": {
+			fun:      Close,
+			evalArgs: true,
+		},
+		"copy": {
+			fun:      Copy,
+			evalArgs: true,
+		},
+		"delete": {
+			fun:      Delete,
+			evalArgs: true,
+		},
 		"len": {
 			fun:      Len,
 			evalArgs: true,
@@ -31,6 +46,18 @@ func (m *Machine) AddBuiltinsToContext() {
 			fun:      Make,
 			evalArgs: false,
 		},
+		"new": {
+			fun:      New,
+			evalArgs: false,
+		},
+		"panic": {
+			fun:      Panic,
+			evalArgs: true,
+		},
+		"recover": {
+			fun:      Recover,
+			evalArgs: true,
+		},
 	}
 
 	for name, val := range builtins {
@@ -44,13 +71,9 @@ func (m *Machine) AddBuiltinsToContext() {
 func (m *Machine) CallBuiltin(fun *Node, args []ast.Expr) (*Node, error) {
 	builtin := fun.Value.(*builtin)
 	if builtin.evalArgs {
-		nodeArgs := make([]*Node, len(args))
-		for i, arg := range args {
-			n, err := m.Evaluate(arg)
-			if err != nil {
-				return nil, errors.WrapPrefix(err, "error evaluating function arguments", 10)
-			}
-			nodeArgs[i] = n
+		nodeArgs, err := m.evalArgs(args)
+		if err != nil {
+			return nil, err
 		}
 		return builtin.fun(m, nodeArgs)
 	} else {
@@ -58,19 +81,154 @@ func (m *Machine) CallBuiltin(fun *Node, args []ast.Expr) (*Node, error) {
 	}
 }
 
+// callBuiltinSpread calls a builtin like append(a, b...), with the elements of
+// the last argument passed separately.
+func (m *Machine) callBuiltinSpread(fun *Node, args []ast.Expr) (*Node, error) {
+	builtin := fun.Value.(*builtin)
+	if !builtin.evalArgs {
+		return nil, errors.New("invalid use of ... with builtin")
+	}
+	nodeArgs, err := m.evalArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	// append(b, s...) appends the bytes of the string s
+	if len(nodeArgs) == 2 && nodeArgs[1].Type.Kind() == types.String &&
+		isByteSlice(nodeArgs[0].Type) {
+		nodeArgs[1] = stringToSlice(nodeArgs[1].Value.(string), nodeArgs[0].Type)
+	}
+	nodeArgs, err = spreadArgs(nodeArgs)
+	if err != nil {
+		return nil, err
+	}
+	return builtin.fun(m, nodeArgs)
+}
+
 // append(s []T, vs ...T) []T
 func Append(_ *Machine, a any) (*Node, error) {
 	args := a.([]*Node)
+	if len(args) == 0 {
+		return nil, errors.New("missing arguments to append")
+	}
 	arr := args[0]
-	vals := args[1:]
-	newValue := arr.Value.([]*Node)
-	newValue = append(newValue, vals...)
-	arr.Value = newValue
-	return arr, nil
+	if arr.Type.Kind() != types.Array {
+		return nil, errors.Errorf("unsupported type %v for append", arr.Type)
+	}
+
+	elemType, _ := arr.Type.Elem()
+	vals := make([]*Node, len(args)-1)
+	for i, val := range args[1:] {
+		var err error
+		vals[i], err = promoteTo(val, elemType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// go's append already grows the backing array the same way, and reuses
+	// it when there is enough capacity
+	return &Node{
+		Type:  arr.Type,
+		Value: append(arr.Value.([]*Node), vals...),
+	}, nil
+}
+
+// cap(v Type) int
+func Cap(_ *Machine, a any) (*Node, error) {
+	args := a.([]*Node)
+	if len(args) != 1 {
+		return nil, errors.Errorf("wrong number of arguments %d to cap", len(args))
+	}
+
+	arg := args[0]
+	var res int
+	switch arg.Type.Kind() {
+	case types.Array:
+		res = cap(arg.Value.([]*Node))
+	case types.Chan:
+		if ch, _ := arg.Value.(*channel); ch != nil {
+			res = ch.capacity
+		}
+	default:
+		return nil, errors.Errorf("unsupported type %v for cap", arg.Type)
+	}
+
+	return NewIntNode(int64(res)), nil
+}
+
+// close(c chan T)
+func Close(m *Machine, a any) (*Node, error) {
+	args := a.([]*Node)
+	if len(args) != 1 {
+		return nil, errors.Errorf("wrong number of arguments %d to close", len(args))
+	}
+
+	return nil, m.closeChannel(args[0])
 }
 
+// copy(dst, src []T) int
+func Copy(_ *Machine, a any) (*Node, error) {
+	args := a.([]*Node)
+	if len(args) != 2 {
+		return nil, errors.Errorf("wrong number of arguments %d to copy", len(args))
+	}
+
+	dst, src := args[0], args[1]
+	if src.Type.Kind() == types.String && isByteSlice(dst.Type) {
+		// strings can be copied into slices of bytes
+		src = stringToSlice(src.Value.(string), dst.Type)
+	}
+	if dst.Type.Kind() != types.Array || src.Type.Kind() != types.Array {
+		return nil, errors.Errorf(
+			"unsupported types %v %v for copy",
+			dst.Type,
+			src.Type,
+		)
+	}
+	if !dst.Type.Equal(src.Type) {
+		return nil, errors.Errorf(
+			"arguments to copy have different element types %v and %v",
+			dst.Type,
+			src.Type,
+		)
+	}
+
+	n := copy(dst.Value.([]*Node), src.Value.([]*Node))
+	return NewIntNode(int64(n)), nil
+}
+
+// delete(m map[K]V, key K)
+func Delete(_ *Machine, a any) (*Node, error) {
+	args := a.([]*Node)
+	if len(args) != 2 {
+		return nil, errors.Errorf("wrong number of arguments %d to delete", len(args))
+	}
+
+	mapNode := args[0]
+	if mapNode.Type.Kind() != types.Map {
+		return nil, errors.Errorf("unsupported type %v for delete", mapNode.Type)
+	}
+	keyType, _ := mapNode.Type.Key()
+	keyNode, err := promoteTo(args[1], keyType)
+	if err != nil {
+		return nil, err
+	}
+	key, err := mapKey(keyNode)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(mapNode.Value.(map[any]*mapEntry), key)
+	return nil, nil
+}
+
+// len(v Type) int
 func Len(_ *Machine, a any) (*Node, error) {
 	args := a.([]*Node)
+	if len(args) != 1 {
+		return nil, errors.Errorf("wrong number of arguments %d to len", len(args))
+	}
+
 	arg := args[0]
 	var res int
 	switch arg.Type.Kind() {
@@ -78,6 +236,12 @@ func Len(_ *Machine, a any) (*Node, error) {
 		res = len(arg.Value.(string))
 	case types.Array:
 		res = len(arg.Value.([]*Node))
+	case types.Map:
+		res = len(arg.Value.(map[any]*mapEntry))
+	case types.Chan:
+		if ch, _ := arg.Value.(*channel); ch != nil {
+			res = len(ch.buf)
+		}
 	default:
 		return nil, errors.Errorf("unsupported type %v for len", arg.Type)
 	}
@@ -88,54 +252,127 @@ func Len(_ *Machine, a any) (*Node, error) {
 // make(t Type, size ...IntegerType) Type
 func Make(m *Machine, a any) (*Node, error) {
 	args := a.([]ast.Expr)
+	if len(args) == 0 {
+		return nil, errors.New("missing argument to make")
+	}
 
-	typeInfo := args[0]
-	switch n := typeInfo.(type) {
-	case *ast.ArrayType:
-		switch len(args) {
+	t, err := m.evalType(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make([]int64, len(args)-1)
+	for i, arg := range args[1:] {
+		size, err := m.Evaluate(arg)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i], err = size.ToInt()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch t.Kind() {
+	case types.Array:
+		var length, capacity int64
+		switch len(sizes) {
+		case 0:
 		case 1:
-			return m.evalArray(n.Elt, []ast.Expr{})
+			length, capacity = sizes[0], sizes[0]
 		case 2:
-			sizeNode := args[1]
-
-			size, err := m.Evaluate(sizeNode)
-			if err != nil {
-				return nil, err
-			}
-			sizeInt, err := size.ToInt()
-			if err != nil {
-				return nil, err
-			}
-
-			return m.evalArray(n.Elt, []ast.Expr{}, sizeInt)
-		case 3:
-			defaultSize := args[1]
-			capacity := args[2]
-
-			size, err := m.Evaluate(defaultSize)
-			if err != nil {
-				return nil, err
-			}
-			sizeInt, err := size.ToInt()
-			if err != nil {
-				return nil, err
-			}
-
-			capacityNode, err := m.Evaluate(capacity)
-			if err != nil {
-				return nil, err
-			}
-			capInt, err := capacityNode.ToInt()
-			if err != nil {
-				return nil, err
-			}
-
-			return m.evalArray(n.Elt, []ast.Expr{}, sizeInt, capInt)
+			length, capacity = sizes[0], sizes[1]
 		default:
 			return nil, errors.Errorf("wrong number of arguments %d to make", len(args))
 		}
+		if length < 0 {
+			return nil, errors.Errorf("negative len argument %d to make", length)
+		}
+		if length > capacity {
+			return nil, errors.Errorf(
+				"len larger than cap in make (%d > %d)",
+				length,
+				capacity,
+			)
+		}
+		elemType, _ := t.Elem()
+		res := make([]*Node, length, capacity)
+		for i := range res[:capacity] {
+			res[:capacity][i] = zeroValue(elemType)
+		}
+		return &Node{
+			Type:  t,
+			Value: res,
+		}, nil
+	case types.Map:
+		if len(sizes) > 1 {
+			return nil, errors.Errorf("wrong number of arguments %d to make", len(args))
+		}
+		return &Node{
+			Type:  t,
+			Value: make(map[any]*mapEntry),
+		}, nil
+	case types.Chan:
+		var capacity int64
+		switch len(sizes) {
+		case 0:
+		case 1:
+			capacity = sizes[0]
+		default:
+			return nil, errors.Errorf("wrong number of arguments %d to make", len(args))
+		}
+		if capacity < 0 {
+			return nil, errors.Errorf("negative buffer argument %d to make", capacity)
+		}
+		return newChannelNode(t, int(capacity)), nil
 	default:
-		return nil, errors.Errorf("unsupported type %v for make", reflect.TypeOf(typeInfo))
+		return nil, errors.Errorf("unsupported type %v for make", t)
+	}
+}
+
+// new(T) *T
+func New(m *Machine, a any) (*Node, error) {
+	args := a.([]ast.Expr)
+	if len(args) != 1 {
+		return nil, errors.Errorf("wrong number of arguments %d to new", len(args))
 	}
 
+	t, err := m.evalType(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return newPointerNode(t, &heapLocation{node: zeroValue(t)}), nil
+}
+
+// panic(v any)
+func Panic(_ *Machine, a any) (*Node, error) {
+	args := a.([]*Node)
+	if len(args) != 1 {
+		return nil, errors.Errorf("wrong number of arguments %d to panic", len(args))
+	}
+	if isUntypedNil(args[0]) {
+		// like go, so that recover can tell there was a panic
+		return nil, runtimePanic("panic called with nil argument")
+	}
+
+	// the value is recovered as an any
+	val, err := promoteTo(args[0], types.AnyType)
+	if err != nil {
+		return nil, err
+	}
+	return nil, errors.Wrap(&PanicError{Value: val}, 1)
+}
+
+// recover() any
+func Recover(m *Machine, a any) (*Node, error) {
+	args := a.([]*Node)
+	if len(args) != 0 {
+		return nil, errors.Errorf("wrong number of arguments %d to recover", len(args))
+	}
+
+	val := m.recoverPanic()
+	if val == nil {
+		return &Node{Type: types.AnyType}, nil
+	}
+	return val, nil
 }
@@ -0,0 +1,153 @@
+package machine
+
+import (
+	"go/token"
+	"math/big"
+
+	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/types"
+)
+
+// Untyped integer constants are exact, like in go. Their values are kept as an
+// int64 when they fit in one, and as a *big.Int otherwise, and they are only
+// checked for overflow once they are given a type. Operations on typed integer
+// constants are exact too, and their results have to fit in their type.
+
+// maxConstBits is the size of the largest integer constant, which is what go
+// allows too.
+const maxConstBits = 512
+
+// constInt returns the value of an integer constant.
+func constInt(node *Node) *big.Int {
+	switch val := node.Value.(type) {
+	case *big.Int:
+		return val
+	case uint64:
+		return new(big.Int).SetUint64(val)
+	default:
+		return big.NewInt(val.(int64))
+	}
+}
+
+// isConst reports whether node is an integer constant, typed or not.
+func isConst(node *Node) bool {
+	return node.untyped || node.constant
+}
+
+// dropConst returns node as a value that is no longer a constant, like when it
+// is stored in a variable.
+func dropConst(node *Node) *Node {
+	if node == nil || !node.constant {
+		return node
+	}
+	res := *node
+	res.constant = false
+	return &res
+}
+
+// typedConst gives the exact result of an operation on typed constants their
+// type t, which it has to fit in.
+func typedConst(res *Node, t types.Type) (*Node, error) {
+	if res.Type.Kind() == types.Bool {
+		return res, nil
+	}
+	res, err := convertConst(res, t.Underlying())
+	if err != nil {
+		return nil, err
+	}
+	res.Type = t
+	res.constant = true
+	return res, nil
+}
+
+// newConstNode returns an untyped integer constant of type t, which is int for
+// integers and int32 for runes.
+func newConstNode(t types.Type, val *big.Int) (*Node, error) {
+	if val.BitLen() > maxConstBits {
+		return nil, errors.New("constant overflow")
+	}
+	if val.IsInt64() {
+		return &Node{Type: t, Value: val.Int64(), untyped: true}, nil
+	}
+	return &Node{Type: t, Value: val, untyped: true}, nil
+}
+
+// checkConst checks that an untyped constant that leaves an expression without
+// being given a type, like a result or an argument of an untyped parameter,
+// fits in the int it will be used as.
+func checkConst(node *Node) error {
+	if val, ok := node.Value.(*big.Int); ok && node.untyped {
+		return errors.Errorf("constant %v overflows int", val)
+	}
+	return nil
+}
+
+// constType returns the type of the result of an operation on two untyped
+// constants. Runes win over integers.
+func constType(nodeX, nodeY *Node) types.Type {
+	if nodeX.Type.Equal(types.IntType) {
+		return nodeY.Type
+	}
+	return nodeX.Type
+}
+
+// constOp applies a binary operator to two untyped integer constants.
+func constOp(op token.Token, nodeX, nodeY *Node) (*Node, error) {
+	x, y := constInt(nodeX), constInt(nodeY)
+	res := new(big.Int)
+	switch op {
+	case token.ADD:
+		res.Add(x, y)
+	case token.SUB:
+		res.Sub(x, y)
+	case token.MUL:
+		res.Mul(x, y)
+	case token.QUO, token.REM:
+		if y.Sign() == 0 {
+			return nil, errors.New("invalid operation: division by zero")
+		}
+		if op == token.QUO {
+			res.Quo(x, y)
+		} else {
+			res.Rem(x, y)
+		}
+	case token.AND:
+		res.And(x, y)
+	case token.OR:
+		res.Or(x, y)
+	case token.XOR:
+		res.Xor(x, y)
+	case token.AND_NOT:
+		res.AndNot(x, y)
+	case token.GTR, token.GEQ, token.LSS, token.LEQ, token.EQL, token.NEQ:
+		return NewBoolNode(bincomp(op, x.Cmp(y), 0)), nil
+	default:
+		return nil, errors.Errorf("operator %v not defined on untyped constants", op)
+	}
+	return newConstNode(constType(nodeX, nodeY), res)
+}
+
+// constShift shifts an untyped integer constant by count.
+func constShift(op token.Token, node *Node, count uint64) (*Node, error) {
+	x := constInt(node)
+	if op == token.SHR {
+		return newConstNode(node.Type, new(big.Int).Rsh(x, uint(min(count, maxConstBits+1))))
+	}
+	if x.Sign() != 0 && uint64(x.BitLen())+count > maxConstBits {
+		return nil, errors.Errorf("constant shift overflow")
+	}
+	return newConstNode(node.Type, new(big.Int).Lsh(x, uint(count)))
+}
+
+// constUnary applies a unary operator to an untyped integer constant.
+func constUnary(op token.Token, node *Node) (*Node, error) {
+	x := constInt(node)
+	switch op {
+	case token.SUB:
+		return newConstNode(node.Type, new(big.Int).Neg(x))
+	case token.XOR:
+		return newConstNode(node.Type, new(big.Int).Not(x))
+	default:
+		return nil, errors.Errorf("operator %v not defined on untyped constants", op)
+	}
+}
@@ -13,6 +13,8 @@ type context struct {
 	Name   string
 
 	storage map[string]*Node
+	// names of the constants in storage, which cannot be assigned to
+	constants map[string]bool
 }
 
 func NewContext(name string) *context {
@@ -32,6 +34,7 @@ func (c *context) NewChildContext(name string) *context {
 
 func (c *context) Reset() {
 	clear(c.storage)
+	clear(c.constants)
 }
 
 func (c *context) Get(name string) *Node {
@@ -46,22 +49,48 @@ func (c *context) Get(name string) *Node {
 	return nil
 }
 
+// lookup returns the context that name is stored in, or nil if it cannot be
+// found.
+func (c *context) lookup(name string) *context {
+	for ctx := c; ctx != nil; ctx = ctx.Parent {
+		if _, ok := ctx.storage[name]; ok {
+			return ctx
+		}
+	}
+
+	return nil
+}
+
 func (c *context) Update(name string, value *Node) error {
 	if _, ok := c.storage[name]; ok {
-		c.storage[name] = value
+		if c.constants[name] {
+			return errors.Errorf("cannot assign to %s (constant)", name)
+		}
+		c.storage[name] = dropConst(value)
 		return nil
 	}
 
 	if c.Parent != nil {
-		c.Parent.Update(name, value)
-		return nil
+		return c.Parent.Update(name, value)
 	}
 
 	return errors.Errorf("cannot find name %s to update to %v", name, value.Value)
 }
 
 func (c *context) Set(name string, value *Node) error {
+	c.storage[name] = dropConst(value)
+	delete(c.constants, name)
+
+	return nil
+}
+
+// SetConst declares the constant name, which cannot be assigned to.
+func (c *context) SetConst(name string, value *Node) error {
 	c.storage[name] = value
+	if c.constants == nil {
+		c.constants = make(map[string]bool)
+	}
+	c.constants[name] = true
 
 	return nil
 }
@@ -0,0 +1,284 @@
+package machine
+
+import (
+	stderrors "errors"
+	"fmt"
+	"go/ast"
+
+	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/types"
+)
+
+// PanicError is returned when a script panics and nothing recovers from it.
+// Hosts can tell it apart from other errors with errors.As.
+type PanicError struct {
+	// the value passed to panic
+	Value *Node
+}
+
+func (p *PanicError) Error() string {
+	return "panic: " + elemToString(p.Value)
+}
+
+// runtimePanic makes the panic of a fault at runtime, like an index out of
+// range, so that deferred calls can recover from it like in go. The value of
+// the panic is an error with the message.
+func runtimePanic(format string, args ...any) error {
+	return errors.Wrap(&PanicError{
+		Value: &Node{
+			Type:  types.GoErrorType,
+			Value: stderrors.New(fmt.Sprintf(format, args...)),
+		},
+	}, 1)
+}
+
+// frame holds the state of a single function call.
+type frame struct {
+	parent *frame
+
+	// deferred calls, in the order they were deferred
+	defers []deferredCall
+	// the panic that is unwinding this frame, if any
+	panicking *PanicError
+	// set while the deferred calls are running
+	runningDefers bool
+}
+
+// deferredCall is a call whose function and arguments have already been
+// evaluated, and only has to be run. Both defer and go statements make these.
+type deferredCall struct {
+	fun  *Node
+	args []*Node
+	// whether the last argument is spread into a variadic parameter
+	spread bool
+}
+
+// evalDeferredCall evaluates the function and arguments of a call without
+// running it.
+func (m *Machine) evalDeferredCall(call *ast.CallExpr) (deferredCall, error) {
+	funNode, err := m.Evaluate(call.Fun)
+	if err != nil {
+		return deferredCall{}, err
+	}
+
+	switch funNode.Type.Kind() {
+	case types.Builtin:
+		if !funNode.Value.(*builtin).evalArgs {
+			return deferredCall{}, errors.New(
+				"this builtin cannot be deferred or run in a goroutine",
+			)
+		}
+	case types.Func:
+	default:
+		return deferredCall{}, errors.Errorf(
+			"cannot call non-function of type %v",
+			funNode.Type,
+		)
+	}
+
+	args, err := m.evalArgs(call.Args)
+	if err != nil {
+		return deferredCall{}, err
+	}
+
+	spread := call.Ellipsis.IsValid()
+	if spread && funNode.Type.Kind() == types.Builtin {
+		args, err = spreadArgs(args)
+		if err != nil {
+			return deferredCall{}, err
+		}
+		spread = false
+	}
+
+	return deferredCall{
+		fun:    funNode,
+		args:   args,
+		spread: spread,
+	}, nil
+}
+
+func (m *Machine) runDeferredCall(call deferredCall) (*Node, error) {
+	if call.fun.Type.Kind() == types.Builtin {
+		return call.fun.Value.(*builtin).fun(m, call.args)
+	}
+	return m.applyFunction(call.fun, call.args, call.spread)
+}
+
+func (m *Machine) evalDefer(stmt *ast.DeferStmt) (*Node, error) {
+	if m.frame == nil {
+		return nil, errors.New("defer outside function")
+	}
+
+	// the function and arguments are evaluated when the defer statement is
+	// run, not when the call is
+	call, err := m.evalDeferredCall(stmt.Call)
+	if err != nil {
+		return nil, err
+	}
+	m.frame.defers = append(m.frame.defers, call)
+	return nil, nil
+}
+
+// runDefers runs the deferred calls of f in reverse order once its function has
+// returned res or failed with err, and works out what the call really
+// returns.
+func (m *Machine) runDefers(f *frame, ft *ast.FuncType, res *Node, err error) (*Node, error) {
+	if len(f.defers) == 0 || errors.Is(err, errAbort) {
+		return res, err
+	}
+
+	var p *PanicError
+	if errors.As(err, &p) {
+		f.panicking = p
+	}
+
+	f.runningDefers = true
+	for i := len(f.defers) - 1; i >= 0; i-- {
+		_, deferErr := m.runDeferredCall(f.defers[i])
+		if deferErr == nil {
+			continue
+		}
+		if errors.Is(deferErr, errAbort) {
+			return nil, deferErr
+		}
+
+		// a panic in a deferred call replaces the one before it
+		err = deferErr
+		f.panicking = nil
+		if errors.As(deferErr, &p) {
+			f.panicking = p
+		}
+	}
+	f.runningDefers = false
+
+	if f.panicking != nil {
+		return nil, err
+	}
+
+	// deferred calls might have changed the named results
+	names := resultNames(ft)
+	recovered := errors.As(err, &p)
+	if names != nil && (err == nil || recovered) {
+		return m.loadResults(names), nil
+	}
+	if recovered {
+		// the function returns normally with zero values
+		return m.zeroResults(ft)
+	}
+	return res, err
+}
+
+// resultTypes returns the types of the results of a function.
+func (m *Machine) resultTypes(ft *ast.FuncType) ([]types.Type, error) {
+	return m.fieldTypes(ft.Results)
+}
+
+// fieldTypes evaluates the types of the parameters or results in a field
+// list. Untyped parameters have a nil type.
+func (m *Machine) fieldTypes(fields *ast.FieldList) ([]types.Type, error) {
+	if fields == nil {
+		return nil, nil
+	}
+
+	res := make([]types.Type, 0, fields.NumFields())
+	for _, field := range fields.List {
+		var t types.Type
+		if field.Type != nil {
+			var err error
+			t, err = m.evalType(field.Type)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for i := 0; i < max(len(field.Names), 1); i++ {
+			res = append(res, t)
+		}
+	}
+	return res, nil
+}
+
+// resultNames returns the names of the results of a function, or nil if they
+// are not named.
+func resultNames(ft *ast.FuncType) []string {
+	if ft.Results == nil || len(ft.Results.List) == 0 ||
+		len(ft.Results.List[0].Names) == 0 {
+		return nil
+	}
+
+	var names []string
+	for _, field := range ft.Results.List {
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
+
+// declareResults declares the named results of a function with their zero
+// values in the current context.
+func (m *Machine) declareResults(ft *ast.FuncType) error {
+	names := resultNames(ft)
+	if names == nil {
+		return nil
+	}
+
+	resultTypes, err := m.resultTypes(ft)
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		m.Context.Set(name, zeroValue(resultTypes[i]))
+	}
+	return nil
+}
+
+// loadResults returns the current values of the named results of a function.
+func (m *Machine) loadResults(names []string) *Node {
+	results := make([]*Node, len(names))
+	for i, name := range names {
+		results[i] = m.Context.Get(name)
+	}
+	return packResults(results)
+}
+
+// zeroResults returns the zero values of the results of a function.
+func (m *Machine) zeroResults(ft *ast.FuncType) (*Node, error) {
+	resultTypes, err := m.resultTypes(ft)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*Node, len(resultTypes))
+	for i, t := range resultTypes {
+		results[i] = zeroValue(t)
+	}
+	return packResults(results), nil
+}
+
+// packResults turns a list of results into what a function call evaluates to.
+func packResults(results []*Node) *Node {
+	switch len(results) {
+	case 0:
+		return nil
+	case 1:
+		return results[0]
+	default:
+		return NewPackingNode(results...)
+	}
+}
+
+// recoverPanic stops the panic unwinding the caller of the running function,
+// if it is a deferred call, and returns the panic value.
+func (m *Machine) recoverPanic() *Node {
+	if m.frame == nil || m.frame.parent == nil {
+		return nil
+	}
+	caller := m.frame.parent
+	if !caller.runningDefers || caller.panicking == nil {
+		return nil
+	}
+
+	p := caller.panicking
+	caller.panicking = nil
+	return p.Value
+}
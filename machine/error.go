@@ -0,0 +1,164 @@
+package machine
+
+import (
+	stderrors "errors"
+	"fmt"
+	"go/ast"
+
+	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/types"
+)
+
+// ScriptError is the go error for an error value of a script type, like a
+// struct with an Error method, that is passed back to the host.
+type ScriptError struct {
+	// the error value in the script
+	Value *Node
+	msg   string
+}
+
+func (e *ScriptError) Error() string {
+	return e.msg
+}
+
+// knownImports are the import paths of the packages that scripts can use.
+var knownImports = map[string]bool{
+	"cmp":                          true,
+	"errors":                       true,
+	"fmt":                          true,
+	"golang.org/x/exp/constraints": true,
+}
+
+// packageFunc returns the function called name from a package that scripts
+// can use, like errors.New, or nil if there is no such function.
+func packageFunc(pkg, name string) *builtin {
+	switch pkg + "." + name {
+	case "errors.New":
+		return &builtin{fun: ErrorsNew, evalArgs: true}
+	case "fmt.Errorf":
+		return &builtin{fun: FmtErrorf, evalArgs: true}
+	default:
+		return nil
+	}
+}
+
+// errors.New(text string) error
+func ErrorsNew(_ *Machine, a any) (*Node, error) {
+	args := a.([]*Node)
+	if len(args) != 1 || args[0].Type.Kind() != types.String {
+		return nil, errors.New("errors.New takes a single string")
+	}
+
+	return &Node{
+		Type:  types.GoErrorType,
+		Value: stderrors.New(args[0].Value.(string)),
+	}, nil
+}
+
+// fmt.Errorf(format string, a ...any) error
+func FmtErrorf(m *Machine, a any) (*Node, error) {
+	args := a.([]*Node)
+	if len(args) == 0 || args[0].Type.Kind() != types.String {
+		return nil, errors.New("fmt.Errorf takes a format string")
+	}
+
+	values := make([]any, len(args)-1)
+	for i, arg := range args[1:] {
+		// errors are passed as go errors, so that %w wraps them
+		if types.Implements(arg.Type, types.ErrorType) == nil {
+			err, callErr := m.toGoError(arg)
+			if callErr != nil {
+				return nil, callErr
+			}
+			values[i] = err
+			continue
+		}
+		values[i] = arg.NodeToValue().Interface()
+	}
+
+	return &Node{
+		Type:  types.GoErrorType,
+		Value: fmt.Errorf(args[0].Value.(string), values...),
+	}, nil
+}
+
+// errorMethod returns the Error method of an error that holds a go error.
+func errorMethod(errNode *Node) *Node {
+	return &Node{
+		Type: types.BuiltinType,
+		Value: &builtin{
+			fun: func(_ *Machine, a any) (*Node, error) {
+				if len(a.([]*Node)) != 0 {
+					return nil, errors.New("too many arguments to Error")
+				}
+				return &Node{
+					Type:  types.StringType,
+					Value: errNode.Value.(error).Error(),
+				}, nil
+			},
+			evalArgs: true,
+		},
+	}
+}
+
+// toGoError converts an error value of a script into a go error. Nil errors
+// are converted to nil.
+func (m *Machine) toGoError(errNode *Node) (error, error) {
+	switch {
+	case isNil(errNode):
+		return nil, nil
+	case errNode.Type.Kind() == types.Error:
+		return errNode.Value.(error), nil
+	}
+
+	meth := m.lookupMethod(errNode.Type, "Error")
+	if meth == nil || types.Implements(errNode.Type, types.ErrorType) != nil {
+		return nil, errors.Errorf("%v does not implement error", errNode.Type)
+	}
+	fun, err := m.bindMethod(meth, nil, errNode)
+	if err != nil {
+		return nil, err
+	}
+	res, err := m.applyFunction(fun, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil || res.Type.Kind() != types.String {
+		return nil, errors.Errorf("method Error of %v does not return a string", errNode.Type)
+	}
+	return &ScriptError{Value: errNode, msg: res.Value.(string)}, nil
+}
+
+// splitError takes the error out of the results of a function whose last
+// result is an error, like func() (float64, error), and returns it as a go
+// error. The other results are returned as they are.
+func (m *Machine) splitError(ft *ast.FuncType, ctx *context, res *Node) (*Node, error) {
+	if ft.Results == nil || res == nil {
+		return res, nil
+	}
+
+	oldContext := m.Context
+	m.Context = ctx
+	resultTypes, err := m.resultTypes(ft)
+	m.Context = oldContext
+	if err != nil {
+		return nil, err
+	}
+	last := len(resultTypes) - 1
+	if last < 0 || !resultTypes[last].Equal(types.ErrorType) {
+		return res, nil
+	}
+
+	results := []*Node{res}
+	if res.Type.Kind() == types.Packing {
+		results = res.Elems
+	}
+	goErr, err := m.toGoError(results[last])
+	if err != nil {
+		return nil, err
+	}
+	if last == 0 {
+		return nil, goErr
+	}
+	return packResults(results[:last]), goErr
+}
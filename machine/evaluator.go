@@ -1,10 +1,13 @@
 package machine
 
 import (
+	"cmp"
 	"fmt"
 	"go/ast"
 	"go/token"
+	"math/big"
 	"reflect"
+	"slices"
 	"strconv"
 
 	"github.com/go-errors/errors"
@@ -31,7 +34,7 @@ func (m *Machine) Evaluate(expr ast.Node) (*Node, error) {
 	case *ast.AssignStmt:
 		node, err = m.evalAssign(n)
 	case *ast.BasicLit, *ast.FuncLit:
-		node = m.evalLit(n)
+		node, err = m.evalLit(n)
 	case *ast.BinaryExpr:
 		node, err = m.evalBinary(n)
 	case *ast.BlockStmt:
@@ -39,31 +42,55 @@ func (m *Machine) Evaluate(expr ast.Node) (*Node, error) {
 	case *ast.BranchStmt:
 		node, err = m.evalBranch(n)
 	case *ast.CallExpr:
-		node, err = m.evalFunctionCall(n.Fun, n.Args)
+		node, err = m.evalFunctionCall(n)
 	case *ast.CompositeLit:
 		node, err = m.evalComposite(n)
 	case *ast.DeclStmt:
 		node, err = m.evalDecl(n)
+	case *ast.File:
+		err = m.evalFile(n)
 	case *ast.ExprStmt:
 		node, err = m.Evaluate(n.X)
 	case *ast.ForStmt:
-		node, err = m.evalFor(n)
+		node, err = m.evalFor(n, "")
 	case *ast.IfStmt:
 		node, err = m.evalIf(n)
 	case *ast.Ident:
 		node, err = m.evalIdent(n)
 	case *ast.IndexExpr:
-		node, err = m.evalIndex(n)
+		node, err = m.evalIndex(n, []ast.Expr{n.Index})
+	case *ast.IndexListExpr:
+		node, err = m.evalIndex(n, n.Indices)
 	case *ast.ParenExpr:
 		node, err = m.Evaluate(n.X)
 	case *ast.RangeStmt:
-		node, err = m.evalRange(n)
+		node, err = m.evalRange(n, "")
 	case *ast.UnaryExpr:
 		node, err = m.evalUnary(n)
 	case *ast.IncDecStmt:
 		node, err = m.evalIncDec(n)
 	case *ast.ReturnStmt:
 		node, err = m.evalReturn(n)
+	case *ast.SelectorExpr:
+		node, err = m.evalSelector(n)
+	case *ast.SliceExpr:
+		node, err = m.evalSlice(n)
+	case *ast.StarExpr:
+		node, err = m.evalStar(n)
+	case *ast.GoStmt:
+		node, err = m.evalGo(n)
+	case *ast.SendStmt:
+		node, err = m.evalSend(n)
+	case *ast.SwitchStmt:
+		node, err = m.evalSwitch(n, "")
+	case *ast.TypeAssertExpr:
+		node, err = m.evalTypeAssert(n)
+	case *ast.TypeSwitchStmt:
+		node, err = m.evalTypeSwitch(n, "")
+	case *ast.LabeledStmt:
+		node, err = m.evalLabeled(n)
+	case *ast.DeferStmt:
+		node, err = m.evalDefer(n)
 	default:
 		err = errors.Errorf("unknown type %v", reflect.TypeOf(expr))
 	}
@@ -82,6 +109,9 @@ func (m *Machine) Evaluate(expr ast.Node) (*Node, error) {
 	return node, err
 }
 
+// errNoValue is returned when the result of a call with no results is used.
+var errNoValue = errors.New("expression with no value used as value")
+
 func (m *Machine) evalIdent(expr *ast.Ident) (*Node, error) {
 	if expr.Obj != nil && expr.Obj.Data != nil {
 		return expr.Obj.Data.(*Node), nil
@@ -94,58 +124,97 @@ func (m *Machine) evalIdent(expr *ast.Ident) (*Node, error) {
 	return node, nil
 }
 
-func (m *Machine) evalLit(lit ast.Node) *Node {
+func (m *Machine) evalLit(lit ast.Node) (*Node, error) {
 	switch n := lit.(type) {
 	case *ast.BasicLit:
-		switch n.Kind {
-		case token.FLOAT:
-			val, _ := strconv.ParseFloat(n.Value, 64)
-			return NewFloatNode(val)
-		case token.INT:
-			val, _ := strconv.ParseInt(n.Value, 10, 64)
-			return NewIntNode(val)
-		case token.CHAR, token.STRING:
-			val, _ := strconv.Unquote(n.Value)
-			return &Node{
-				Type:  types.StringType,
-				Value: val,
-			}
-		}
+		return basicLitToNode(n)
 	case *ast.FuncLit:
 		return &Node{
 			Type:    types.FuncType,
 			Value:   lit,
 			Context: m.Context,
-		}
+		}, nil
 	}
 
-	return nil
+	return nil, nil
+}
+
+// basicLitToNode converts a literal into a Node. Integer and rune literals are
+// untyped constants.
+func basicLitToNode(lit *ast.BasicLit) (*Node, error) {
+	switch lit.Kind {
+	case token.FLOAT:
+		val, err := strconv.ParseFloat(lit.Value, 64)
+		if err != nil {
+			return nil, errors.Errorf("floating-point constant %s overflows float64", lit.Value)
+		}
+		return NewFloatNode(val), nil
+	case token.INT:
+		// base 0 understands the prefixes and underscores of go literals
+		val, ok := new(big.Int).SetString(lit.Value, 0)
+		if !ok {
+			return nil, errors.Errorf("invalid integer literal %s", lit.Value)
+		}
+		return newConstNode(types.IntType, val)
+	case token.CHAR:
+		val, _, _, err := strconv.UnquoteChar(lit.Value[1:len(lit.Value)-1], '\'')
+		if err != nil {
+			return nil, errors.Errorf("invalid rune literal %s", lit.Value)
+		}
+		return &Node{Type: types.Int32Type, Value: int64(val), untyped: true}, nil
+	case token.STRING:
+		val, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return nil, errors.Errorf("invalid string literal %s", lit.Value)
+		}
+		return &Node{
+			Type:  types.StringType,
+			Value: val,
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported literal %s", lit.Value)
+	}
 }
 
 func (m *Machine) evalBlock(stmt *ast.BlockStmt) (*Node, error) {
 	var res *Node
 	var err error
-	for _, stmt := range stmt.List {
-		res, err = m.Evaluate(stmt)
+	for i := 0; i < len(stmt.List); i++ {
+		res, err = m.Evaluate(stmt.List[i])
 		if err != nil {
 			return nil, err
 		}
-		if res != nil {
-			if res.IsReturnValue {
-				break
-			}
-			if res.IsContinue {
-				break
-			}
-			if res.IsBreak {
-				break
+		if !res.interrupts() {
+			continue
+		}
+
+		if res.IsGoto {
+			// jump if the label is in this block, otherwise let the
+			// enclosing blocks look for it
+			target := labelIndex(stmt.List, res.Label)
+			if target != -1 {
+				i = target - 1
+				res = nil
+				continue
 			}
 		}
+		break
 	}
 
 	return res, nil
 }
 
+// labelIndex returns the index of the statement with the given label, or -1.
+func labelIndex(list []ast.Stmt, label string) int {
+	for i, stmt := range list {
+		labeled, ok := stmt.(*ast.LabeledStmt)
+		if ok && labeled.Label.Name == label {
+			return i
+		}
+	}
+	return -1
+}
+
 func downgradeAssignArithmeticToken(tok token.Token) token.Token {
 	switch tok {
 	case token.ADD_ASSIGN:
@@ -158,107 +227,268 @@ func downgradeAssignArithmeticToken(tok token.Token) token.Token {
 		return token.QUO
 	case token.REM_ASSIGN:
 		return token.REM
+	case token.AND_ASSIGN:
+		return token.AND
+	case token.OR_ASSIGN:
+		return token.OR
+	case token.XOR_ASSIGN:
+		return token.XOR
+	case token.SHL_ASSIGN:
+		return token.SHL
+	case token.SHR_ASSIGN:
+		return token.SHR
+	case token.AND_NOT_ASSIGN:
+		return token.AND_NOT
 	default:
 		return -1
 	}
 }
 
+// evalAssign evaluates assignments. Like in go, the operands of index
+// expressions and pointer indirections on the left are evaluated first, then
+// the values on the right, and then the assignments are done from left to
+// right.
 func (m *Machine) evalAssign(stmt *ast.AssignStmt) (*Node, error) {
 	switch stmt.Tok {
 	case token.ADD_ASSIGN, token.SUB_ASSIGN, token.MUL_ASSIGN,
-		token.QUO_ASSIGN, token.REM_ASSIGN:
+		token.QUO_ASSIGN, token.REM_ASSIGN, token.AND_ASSIGN, token.OR_ASSIGN,
+		token.XOR_ASSIGN, token.SHL_ASSIGN, token.SHR_ASSIGN,
+		token.AND_NOT_ASSIGN:
 		if len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 {
 			return nil, errors.Errorf("syntax error at %v", stmt.Tok)
 		}
 
-		node, err := m.evalBinary(&ast.BinaryExpr{
-			X:  stmt.Lhs[0],
-			Op: downgradeAssignArithmeticToken(stmt.Tok),
-			Y:  stmt.Rhs[0],
-		})
+		// the left side is only evaluated once, so a[f()] += 1 calls f
+		// once
+		target, err := m.evalAssignTarget(stmt.Lhs[0])
 		if err != nil {
 			return nil, err
 		}
-
-		name := stmt.Lhs[0].(*ast.Ident).Name
-		err = m.Context.Update(name, node)
+		rhs, err := m.Evaluate(stmt.Rhs[0])
+		if err != nil {
+			return nil, err
+		}
+		if rhs == nil {
+			return nil, errNoValue
+		}
+		old, err := target.loc.load()
+		if err != nil {
+			return nil, err
+		}
+		node, err := binaryOp(downgradeAssignArithmeticToken(stmt.Tok), old, rhs)
 		if err != nil {
 			return nil, err
 		}
+		if target.t == nil {
+			// variables are not typed, so they take the type of the result
+			return nil, target.loc.store(node)
+		}
+		return nil, target.assign(node)
 
-	case token.ASSIGN, token.DEFINE:
-		rhs := make([]*Node, 0, 1)
-		for _, expr := range stmt.Rhs {
-			node, err := m.Evaluate(expr)
+	case token.ASSIGN:
+		targets := make([]assignTarget, len(stmt.Lhs))
+		for i, lhs := range stmt.Lhs {
+			var err error
+			targets[i], err = m.evalAssignTarget(lhs)
 			if err != nil {
 				return nil, err
 			}
-
-			if node.Elems != nil {
-				rhs = append(rhs, node.Elems...)
-			} else {
-				rhs = append(rhs, node)
+		}
+		rhs, err := m.evalAssignValues(stmt)
+		if err != nil {
+			return nil, err
+		}
+		for i, target := range targets {
+			err := target.assign(rhs[i])
+			if err != nil {
+				return nil, errors.WrapPrefix(err, "cannot assign", 10)
 			}
 		}
 
-		if len(stmt.Lhs) != len(rhs) {
-			return nil, errors.Errorf(
-				"assignment mismatch: %d variables on lhs but %d values on rhs",
-				len(stmt.Lhs),
-				len(rhs),
-			)
+	case token.DEFINE:
+		rhs, err := m.evalAssignValues(stmt)
+		if err != nil {
+			return nil, err
 		}
-
-		for i := range stmt.Lhs {
-			err := m.assignRhsToLhs(stmt.Lhs[i], rhs[i], stmt.Tok)
+		for i, lhs := range stmt.Lhs {
+			ident, ok := lhs.(*ast.Ident)
+			if !ok {
+				return nil, errors.Errorf("non-name %v on left side of :=", lhs)
+			}
+			val, err := defaultType(rhs[i])
 			if err != nil {
-				return nil, errors.WrapPrefix(
-					err,
-					"cannot assign assignment",
-					10,
-				)
+				return nil, errors.WrapPrefix(err, "cannot assign", 10)
 			}
+			m.Context.Set(ident.Name, val)
 		}
 	}
 
 	return nil, nil
 }
 
-func (m *Machine) assignRhsToLhs(lhs ast.Expr, rhs *Node, tok token.Token) error {
-	switch n := lhs.(type) {
-	case *ast.Ident:
-		name := n.Name
+// evalAssignValues evaluates the right side of an assignment into one value for
+// each expression on the left.
+func (m *Machine) evalAssignValues(stmt *ast.AssignStmt) ([]*Node, error) {
+	rhs := make([]*Node, 0, len(stmt.Rhs))
+	for _, expr := range stmt.Rhs {
+		var node *Node
 		var err error
-		if tok == token.ASSIGN {
-			err = m.Context.Update(name, rhs)
+		if len(stmt.Lhs) == 2 && len(stmt.Rhs) == 1 {
+			node, err = m.evalCommaOk(expr)
 		} else {
-			err = m.Context.Set(name, rhs)
+			node, err = m.Evaluate(expr)
 		}
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if node == nil {
+			return nil, errNoValue
+		}
+
+		if node.Elems != nil {
+			rhs = append(rhs, node.Elems...)
+		} else {
+			rhs = append(rhs, node)
+		}
+	}
+
+	if len(stmt.Lhs) != len(rhs) {
+		return nil, errors.Errorf(
+			"assignment mismatch: %d variables on lhs but %d values on rhs",
+			len(stmt.Lhs),
+			len(rhs),
+		)
+	}
+	return rhs, nil
+}
+
+// assignTarget is where the value of an assignment goes.
+type assignTarget struct {
+	loc location
+	// the type of the values the location holds, or nil if it is only
+	// known from the value already in it
+	t types.Type
+}
+
+// evalAssignTarget evaluates an expression on the left side of an assignment.
+func (m *Machine) evalAssignTarget(expr ast.Expr) (assignTarget, error) {
+	switch n := expr.(type) {
+	case *ast.Ident:
+		if n.Name == "_" {
+			return assignTarget{loc: blankLocation{}}, nil
 		}
 	case *ast.IndexExpr:
-		indexNode, err := m.Evaluate(n.Index)
+		xNode, err := m.Evaluate(n.X)
+		if err != nil {
+			return assignTarget{}, err
+		}
+		elemType, _ := xNode.Type.Elem()
+		var loc location
+		switch xNode.Type.Kind() {
+		case types.Array:
+			loc, err = m.evalElemLocation(xNode, n.Index)
+		case types.Map:
+			loc, err = m.evalMapEntryLocation(xNode, n.Index)
+		default:
+			err = errors.Errorf("cannot assign to index of %v", xNode.Type)
+		}
+		return assignTarget{loc: loc, t: elemType}, err
+	case *ast.StarExpr:
+		ptr, err := m.Evaluate(n.X)
+		if err != nil {
+			return assignTarget{}, err
+		}
+		loc, err := deref(ptr)
+		if err != nil {
+			return assignTarget{}, err
+		}
+		elemType, _ := ptr.Type.Elem()
+		return assignTarget{loc: loc, t: elemType}, nil
+	case *ast.ParenExpr:
+		return m.evalAssignTarget(n.X)
+	}
+
+	// fields promote the values stored in them themselves
+	loc, err := m.evalLocation(expr)
+	return assignTarget{loc: loc}, err
+}
+
+// assign stores a value in the target.
+func (a assignTarget) assign(rhs *Node) error {
+	var err error
+	if a.t != nil {
+		rhs, err = promoteTo(rhs, a.t)
 		if err != nil {
 			return err
 		}
-		index, err := indexNode.ToInt()
-		arrNode, err := m.Evaluate(n.X)
-		arr := arrNode.Value.([]*Node)
-		arr[index] = rhs
+		return a.loc.store(rhs)
+	}
+
+	// constants and nil take the type of the variable they are assigned to
+	old, err := a.loc.load()
+	switch {
+	case err != nil || old == nil || old.Type == nil:
+		rhs, err = defaultType(rhs)
+	case old.boxed && isUntypedNil(rhs):
+		rhs = zeroValue(types.AnyType)
+	case old.boxed:
+		// interfaces can hold values of any type
+		rhs, err = defaultType(rhs)
+		if err == nil {
+			rhs = box(rhs)
+		}
+	case isUntypedNil(rhs) && old.Type.Kind() == types.Struct:
+		// only interfaces can hold structs and be set to nil
+		rhs = zeroValue(types.AnyType)
+	case isUntypedNil(rhs) ||
+		rhs.untyped && (old.Type.Kind() == types.Int || old.Type.Kind() == types.Uint):
+		rhs, err = promoteTo(rhs, old.Type)
 	default:
-		return errors.Errorf("unknown type %v", reflect.TypeOf(lhs))
+		rhs, err = defaultType(rhs)
 	}
-	return nil
+	if err != nil {
+		return err
+	}
+	return a.loc.store(rhs)
 }
 
 func (m *Machine) evalDecl(n *ast.DeclStmt) (*Node, error) {
 	decl := n.Decl.(*ast.GenDecl)
-	var res *Node
+	switch decl.Tok {
+	case token.TYPE:
+		return nil, m.evalTypeDecl(decl)
+	case token.CONST:
+		return nil, m.evalConstDecl(decl)
+	}
+
 	for _, spec := range decl.Specs {
 		s := spec.(*ast.ValueSpec)
+		var t types.Type
+		if s.Type != nil {
+			var err error
+			t, err = m.evalType(s.Type)
+			if err != nil {
+				return nil, err
+			}
+		}
+		values, err := m.evalVarValues(s)
+		if err != nil {
+			return nil, err
+		}
 		for i, name := range s.Names {
-			res, err := m.Evaluate(s.Values[i])
+			var res *Node
+			switch {
+			case values == nil:
+				// var x T
+				if types.IsConstraint(t) {
+					return nil, errors.Errorf("cannot use %v outside a type constraint", t)
+				}
+				res = zeroValue(t)
+			case t != nil:
+				res, err = promoteTo(values[i], t)
+			default:
+				res, err = defaultType(values[i])
+			}
 			if err != nil {
 				return nil, err
 			}
@@ -269,327 +499,1693 @@ func (m *Machine) evalDecl(n *ast.DeclStmt) (*Node, error) {
 		}
 	}
 
-	return res, nil
+	return nil, nil
 }
 
-func (m *Machine) evalIndex(expr *ast.IndexExpr) (*Node, error) {
-	arrNode, err := m.Evaluate(expr.X)
-	if err != nil {
-		return nil, err
+// evalVarValues evaluates the values in a var declaration, one for each name.
+// It returns nil if the declaration has no values.
+func (m *Machine) evalVarValues(s *ast.ValueSpec) ([]*Node, error) {
+	if len(s.Values) == 0 {
+		return nil, nil
 	}
-	arr := arrNode.Value.([]*Node)
-	indexNode, err := m.Evaluate(expr.Index)
-	if err != nil {
-		return nil, err
+
+	var values []*Node
+	if len(s.Names) > 1 && len(s.Values) == 1 {
+		// var a, b = f()
+		res, err := m.Evaluate(s.Values[0])
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case res == nil:
+		case res.Type.Kind() == types.Packing:
+			values = res.Elems
+		default:
+			values = []*Node{res}
+		}
+	} else {
+		for _, value := range s.Values {
+			res, err := m.Evaluate(value)
+			if err != nil {
+				return nil, err
+			}
+			if res == nil {
+				return nil, errNoValue
+			}
+			values = append(values, res)
+		}
 	}
-	index, err := indexNode.ToInt()
-	if err != nil {
-		return nil, err
+
+	if len(values) != len(s.Names) {
+		return nil, errors.Errorf(
+			"assignment mismatch: %d variables but %d values",
+			len(s.Names),
+			len(values),
+		)
 	}
-	return arr[int(index)], nil
+	return values, nil
 }
 
-func (m *Machine) evalComposite(lit *ast.CompositeLit) (*Node, error) {
-	switch n := lit.Type.(type) {
-	case *ast.ArrayType:
-		return m.evalArray(n.Elt, lit.Elts)
-	default:
-		return nil, errors.Errorf("unsupported composite type %v", n)
+// evalFile declares everything in a file in the current context. Types are
+// declared first and functions second, so that the constants and variables
+// after them can use them regardless of the order they are written in.
+func (m *Machine) evalFile(file *ast.File) error {
+	for _, spec := range file.Imports {
+		// the packages scripts can use are always there, so importing
+		// them does nothing
+		path, _ := strconv.Unquote(spec.Path.Value)
+		if spec.Name != nil || !knownImports[path] {
+			return errors.Errorf("unsupported import %s", spec.Path.Value)
+		}
 	}
-}
 
-// stringToType converts an ast.Ident.Name string into the corresponding type.
-func stringToType(str string) (types.Type, error) {
-	switch str {
-	case "string":
-		return types.StringType, nil
-	case "float32", "float64":
-		return types.FloatType, nil
-	case "int", "int8", "int16", "int32", "int64":
-		return types.IntType, nil
-	default:
-		return nil, errors.Errorf("unknown type identifier %s", str)
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.TYPE {
+			err := m.evalTypeDecl(decl)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ast.FuncDecl); ok {
+			err := m.evalFuncDecl(decl)
+			if err != nil {
+				return errors.WrapPrefix(err, "cannot declare "+decl.Name.Name, 10)
+			}
+		}
+	}
+	for _, decl := range file.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok &&
+			(decl.Tok == token.CONST || decl.Tok == token.VAR) {
+			_, err := m.evalDecl(&ast.DeclStmt{Decl: decl})
+			if err != nil {
+				return err
+			}
+		}
 	}
+
+	return nil
 }
 
-func (m *Machine) evalArray(elementAstType ast.Node, elems []ast.Expr, lens ...int64) (*Node, error) {
-	var res []*Node
+// evalConstDecl declares the constants in a const declaration in the current
+// context.
+func (m *Machine) evalConstDecl(decl *ast.GenDecl) error {
+	var values []ast.Expr
+	var typeExpr ast.Expr
+	for iota, spec := range decl.Specs {
+		s := spec.(*ast.ValueSpec)
+		if s.Values != nil {
+			values = s.Values
+			typeExpr = s.Type
+		}
+		// a spec without values repeats the ones before it
+		if len(s.Names) != len(values) {
+			return errors.Errorf(
+				"wrong number of values in const declaration, have %d want %d",
+				len(values),
+				len(s.Names),
+			)
+		}
 
-	switch len(lens) {
-	case 0:
-		res = make([]*Node, 0, len(elems))
-	case 1:
-		res = make([]*Node, lens[0])
-	case 2:
-		res = make([]*Node, lens[0], lens[1])
-	default:
-		return nil, errors.Errorf(
-			"unsupported number of len arguments %d",
-			len(lens),
-		)
-	}
+		var t types.Type
+		if typeExpr != nil {
+			var err error
+			t, err = m.evalType(typeExpr)
+			if err != nil {
+				return err
+			}
+		}
 
-	var elemType types.Type
-	var err error
-	switch n := elementAstType.(type) {
-	case *ast.Ident:
-		elemType, err = stringToType(n.Name)
+		// iota is only visible while evaluating the values
+		declContext := m.Context
+		m.Context = declContext.NewChildContext("const decl")
+		m.Context.Set("iota", &Node{
+			Type:    types.IntType,
+			Value:   int64(iota),
+			untyped: true,
+		})
+		nodes, err := m.evalArgs(values)
+		m.Context = declContext
 		if err != nil {
-			return nil, err
+			return err
 		}
-		// This is an array of elements that are not arrays.
-		for _, elem := range elems {
-			elemNode, err := m.Evaluate(elem)
-			if err != nil {
-				return nil, err
-			}
-			if !elemNode.Type.Equal(elemType) {
-				// try to promote types
-				if elemType.Kind() == types.Float {
-					elemNode.Value, err = elemNode.ToFloat()
-					elemNode.Type = types.FloatType
-					if err != nil {
-						return nil, err
-					}
-				} else {
-					return nil, errors.Errorf(
-						"array type mismatch, element %v is not a %v",
-						elemNode,
-						elemType,
-					)
+
+		for i, name := range s.Names {
+			node := nodes[i]
+			if t != nil {
+				node, err = promoteTo(node, t)
+				if err != nil {
+					return err
+				}
+				kind := t.Kind()
+				if kind == types.Int || kind == types.Uint {
+					// operations on it are checked for overflow
+					res := *node
+					res.constant = true
+					node = &res
 				}
 			}
-			res = append(res, elemNode)
+			m.Context.SetConst(name.Name, node)
 		}
-	case *ast.ArrayType:
-		// Array of arrays. we have to eval each elem again
+	}
 
-		// get type first
-		emptyNode, err := m.evalArray(n.Elt, []ast.Expr{})
-		if err != nil {
-			return nil, err
-		}
-		elemType = emptyNode.Type
+	return nil
+}
 
-		for _, elem := range elems {
-			elemLit := elem.(*ast.CompositeLit)
-			elemNode, err := m.evalArray(n.Elt, elemLit.Elts)
+// evalTypeDecl declares the types in a type declaration in the current context.
+func (m *Machine) evalTypeDecl(decl *ast.GenDecl) error {
+	for _, spec := range decl.Specs {
+		s := spec.(*ast.TypeSpec)
+		if s.Assign.IsValid() {
+			// type alias
+			t, err := m.evalType(s.Type)
 			if err != nil {
-				return nil, err
+				return err
 			}
-			if !elemNode.Type.Equal(elemType) {
-				return nil, errors.Errorf(
-					"element %v type of %v incompatible with array type %v",
-					elemNode,
-					elemNode.Type,
-					elemType,
-				)
-			}
-			res = append(res, elemNode)
+			m.Context.Set(s.Name.Name, NewTypeNode(t))
+			continue
+		}
+
+		if s.TypeParams != nil {
+			// the type is made when it is instantiated
+			m.Context.Set(s.Name.Name, &Node{
+				Type: types.TypeNameType,
+				Value: &genericType{
+					spec:    s,
+					context: m.Context,
+				},
+			})
+			continue
+		}
+
+		// the name has to be declared before the underlying type is
+		// evaluated in case the type refers to itself
+		t := types.NamedOf(s.Name.Name)
+		m.Context.Set(s.Name.Name, NewTypeNode(t))
+		underlying, err := m.evalType(s.Type)
+		if err != nil {
+			return errors.WrapPrefix(err, "cannot declare type "+s.Name.Name, 10)
 		}
+		t.SetUnderlying(underlying)
 	}
 
-	return &Node{
-		Type:  types.ArrayOf(elemType),
-		Value: res,
-	}, nil
+	return nil
 }
 
-func (m *Machine) evalIf(n *ast.IfStmt) (*Node, error) {
-	// save machine context
-	oldContext := m.Context
-	// context for the stuff in (...)
-	ifContext := oldContext.NewChildContext("if stmt")
-	m.Context = ifContext
+func (m *Machine) evalSelector(expr *ast.SelectorExpr) (*Node, error) {
+	if pkg, ok := expr.X.(*ast.Ident); ok && m.Context.Get(pkg.Name) == nil {
+		// functions from packages, like errors.New
+		if fun := packageFunc(pkg.Name, expr.Sel.Name); fun != nil {
+			return &Node{Type: types.BuiltinType, Value: fun}, nil
+		}
+	}
 
-	if n.Init != nil {
-		_, err := m.Evaluate(n.Init)
+	xNode, err := m.Evaluate(expr.X)
+	if err != nil {
+		return nil, err
+	}
+	if xNode.Type.Kind() == types.Error && expr.Sel.Name == "Error" {
+		return errorMethod(xNode), nil
+	}
+	if meth := m.lookupMethod(xNode.Type, expr.Sel.Name); meth != nil {
+		return m.bindMethod(meth, expr.X, xNode)
+	}
+	if xNode.Type.Kind() == types.Interface {
+		// only nil interfaces have this kind
+		return nil, runtimePanic(
+			"runtime error: invalid memory address or nil pointer dereference calling %s",
+			expr.Sel.Name,
+		)
+	}
+	if xNode.Type.Kind() == types.Pointer {
+		// p.X is shorthand for (*p).X
+		loc, err := deref(xNode)
+		if err != nil {
+			return nil, err
+		}
+		xNode, err = loc.load()
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	cond, err := m.Evaluate(n.Cond)
+	i, err := fieldIndex(xNode.Type, expr.Sel.Name)
 	if err != nil {
 		return nil, err
 	}
+	return xNode.Value.([]*Node)[i], nil
+}
 
-	// context for the stuff in the if block
-	blockContext := ifContext.NewChildContext("if block")
-	m.Context = blockContext
-	var res *Node
-	if cond.Type.Kind() != types.Bool {
-		return nil, errors.New("if condition evaluated to a non-boolean")
+// fieldIndex returns the index of the field called name in a struct type.
+func fieldIndex(t types.Type, name string) (int, error) {
+	if t.Kind() == types.Struct {
+		fields, _ := t.Fields()
+		for i, field := range fields {
+			if field.Name == name {
+				return i, nil
+			}
+		}
 	}
 
-	if cond.Value.(bool) {
-		res, err = m.Evaluate(n.Body)
-	} else if n.Else != nil {
-		res, err = m.Evaluate(n.Else)
+	return 0, errors.Errorf("type %v has no field or method %s", t, name)
+}
+
+// evalIndex evaluates x[index], or x[a, b] which only instantiates generic
+// functions and types.
+func (m *Machine) evalIndex(expr ast.Expr, indices []ast.Expr) (*Node, error) {
+	x, _ := splitIndex(expr)
+	xNode, err := m.Evaluate(x)
+	if err != nil {
+		return nil, err
+	}
+	return m.indexNode(xNode, indices)
+}
+
+// indexNode indexes the already evaluated xNode.
+func (m *Machine) indexNode(xNode *Node, indices []ast.Expr) (*Node, error) {
+	switch kind := xNode.Type.Kind(); {
+	case kind == types.Func || kind == types.TypeName:
+		return m.instantiate(xNode, indices)
+	case len(indices) != 1:
+		return nil, errors.Errorf("unexpected comma in index of type %v", xNode.Type)
+	case kind == types.Array:
+		return m.indexArray(xNode, indices[0])
+	case kind == types.Map:
+		val, _, err := m.indexMap(xNode, indices[0])
+		return val, err
+	case kind == types.String:
+		return m.indexString(xNode, indices[0])
+	default:
+		return nil, errors.Errorf("cannot index type %v", xNode.Type)
+	}
+}
+
+// indexString returns the byte at an index of a string.
+func (m *Machine) indexString(strNode *Node, indexExpr ast.Expr) (*Node, error) {
+	str := strNode.Value.(string)
+	indexNode, err := m.Evaluate(indexExpr)
+	if err != nil {
+		return nil, err
+	}
+	index, err := indexNode.ToInt()
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= int64(len(str)) {
+		return nil, runtimePanic(
+			"runtime error: index out of range [%d] with length %d",
+			index,
+			len(str),
+		)
+	}
+	return &Node{Type: types.Uint8Type, Value: uint64(str[index])}, nil
+}
+
+func (m *Machine) indexArray(arrNode *Node, indexExpr ast.Expr) (*Node, error) {
+	arr := arrNode.Value.([]*Node)
+	indexNode, err := m.Evaluate(indexExpr)
+	if err != nil {
+		return nil, err
+	}
+	index, err := indexNode.ToInt()
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= int64(len(arr)) {
+		return nil, runtimePanic(
+			"runtime error: index out of range [%d] with length %d",
+			index,
+			len(arr),
+		)
+	}
+	return arr[int(index)], nil
+}
+
+// evalSlice evaluates slice expressions like a[lo:hi] and a[lo:hi:max]. The
+// result shares its backing array with the operand.
+func (m *Machine) evalSlice(expr *ast.SliceExpr) (*Node, error) {
+	xNode, err := m.Evaluate(expr.X)
+	if err != nil {
+		return nil, err
+	}
+	if xNode.Type.Kind() == types.String {
+		return m.sliceString(xNode, expr)
+	}
+	if xNode.Type.Kind() != types.Array {
+		return nil, errors.Errorf("cannot slice type %v", xNode.Type)
+	}
+	arr := xNode.Value.([]*Node)
+
+	// missing indices default to the start and end of the array
+	lo, err := m.evalSliceIndex(expr.Low, 0)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := m.evalSliceIndex(expr.High, len(arr))
+	if err != nil {
+		return nil, err
+	}
+	max, err := m.evalSliceIndex(expr.Max, cap(arr))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case max > cap(arr):
+		return nil, runtimePanic(
+			"runtime error: slice bounds out of range [::%d] with capacity %d",
+			max,
+			cap(arr),
+		)
+	case hi > max:
+		return nil, runtimePanic("runtime error: slice bounds out of range [:%d:%d]", hi, max)
+	case lo > hi:
+		return nil, runtimePanic("runtime error: slice bounds out of range [%d:%d]", lo, hi)
+	}
+
+	return &Node{
+		Type:  xNode.Type,
+		Value: arr[lo:hi:max],
+	}, nil
+}
+
+// sliceString evaluates s[lo:hi] for a string s.
+func (m *Machine) sliceString(strNode *Node, expr *ast.SliceExpr) (*Node, error) {
+	if expr.Slice3 {
+		return nil, errors.New("3-index slice of string")
+	}
+
+	str := strNode.Value.(string)
+	lo, err := m.evalSliceIndex(expr.Low, 0)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := m.evalSliceIndex(expr.High, len(str))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case hi > len(str):
+		return nil, runtimePanic(
+			"runtime error: slice bounds out of range [:%d] with length %d",
+			hi,
+			len(str),
+		)
+	case lo > hi:
+		return nil, runtimePanic("runtime error: slice bounds out of range [%d:%d]", lo, hi)
+	}
+
+	return &Node{
+		Type:  strNode.Type,
+		Value: str[lo:hi],
+	}, nil
+}
+
+func (m *Machine) evalSliceIndex(expr ast.Expr, def int) (int, error) {
+	if expr == nil {
+		return def, nil
+	}
+
+	node, err := m.Evaluate(expr)
+	if err != nil {
+		return 0, err
+	}
+	index, err := node.ToInt()
+	if err != nil {
+		return 0, err
+	}
+	if index < 0 {
+		return 0, errors.Errorf("invalid slice index %d (index must be non-negative)", index)
+	}
+	return int(index), nil
+}
+
+// indexMap looks up a key in a map. If the key is not present, the zero value
+// of the map's element type is returned, and the returned bool will be false.
+func (m *Machine) indexMap(mapNode *Node, keyExpr ast.Expr) (*Node, bool, error) {
+	keyType, _ := mapNode.Type.Key()
+	elemType, _ := mapNode.Type.Elem()
+
+	keyNode, err := m.Evaluate(keyExpr)
+	if err != nil {
+		return nil, false, err
+	}
+	keyNode, err = promoteTo(keyNode, keyType)
+	if err != nil {
+		return nil, false, err
+	}
+	key, err := mapKey(keyNode)
+	if err != nil {
+		return nil, false, err
+	}
+
+	entry, ok := mapNode.Value.(map[any]*mapEntry)[key]
+	if !ok {
+		return zeroValue(elemType), false, nil
+	}
+	return entry.Value, true, nil
+}
+
+// evalCommaOk evaluates the right hand side of a two valued assignment like
+// v, ok := m[k]. Expressions that do not have a comma ok form are evaluated
+// as usual.
+func (m *Machine) evalCommaOk(expr ast.Expr) (*Node, error) {
+	switch n := expr.(type) {
+	case *ast.IndexExpr:
+		xNode, err := m.Evaluate(n.X)
+		if err != nil {
+			return nil, err
+		}
+		if xNode.Type.Kind() != types.Map {
+			// only maps give a second value, so this is one value
+			// too few
+			return m.indexNode(xNode, []ast.Expr{n.Index})
+		}
+
+		val, ok, err := m.indexMap(xNode, n.Index)
+		if err != nil {
+			return nil, err
+		}
+		return NewPackingNode(val, NewBoolNode(ok)), nil
+	case *ast.UnaryExpr:
+		if n.Op != token.ARROW {
+			return m.Evaluate(expr)
+		}
+		chNode, err := m.Evaluate(n.X)
+		if err != nil {
+			return nil, err
+		}
+		val, ok, err := m.recv(chNode)
+		if err != nil {
+			return nil, err
+		}
+		return NewPackingNode(val, NewBoolNode(ok)), nil
+	case *ast.TypeAssertExpr:
+		xNode, t, err := m.evalTypeAssertOperands(n)
+		if err != nil {
+			return nil, err
+		}
+		if !hasType(xNode, t) {
+			return NewPackingNode(zeroValue(t), NewBoolNode(false)), nil
+		}
+		return NewPackingNode(assertedValue(xNode, t), NewBoolNode(true)), nil
+	default:
+		return m.Evaluate(expr)
+	}
+}
+
+// hasType reports whether the value in node has type t. Nil interfaces do not
+// have any type.
+func hasType(node *Node, t types.Type) bool {
+	if node.Type.Kind() == types.Interface {
+		return false
+	}
+	if t.Kind() == types.Interface {
+		return types.Implements(node.Type, t) == nil
+	}
+	return node.Type.Equal(t)
+}
+
+// assertedValue returns the value in node as the type t it was asserted to
+// have. Only interfaces keep the value boxed.
+func assertedValue(node *Node, t types.Type) *Node {
+	if t.Kind() == types.Interface {
+		return node
+	}
+	return unbox(node)
+}
+
+func (m *Machine) evalTypeAssertOperands(
+	expr *ast.TypeAssertExpr,
+) (*Node, types.Type, error) {
+	if expr.Type == nil {
+		return nil, nil, errors.New("use of .(type) outside type switch")
+	}
+	xNode, err := m.Evaluate(expr.X)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := m.evalType(expr.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+	return xNode, t, nil
+}
+
+func (m *Machine) evalTypeAssert(expr *ast.TypeAssertExpr) (*Node, error) {
+	xNode, t, err := m.evalTypeAssertOperands(expr)
+	if err != nil {
+		return nil, err
+	}
+	if !hasType(xNode, t) {
+		return nil, runtimePanic(
+			"interface conversion: interface is %v, not %v",
+			xNode.Type,
+			t,
+		)
+	}
+	return assertedValue(xNode, t), nil
+}
+
+func (m *Machine) evalComposite(lit *ast.CompositeLit) (*Node, error) {
+	t, err := m.evalType(lit.Type)
+	if err != nil {
+		return nil, err
+	}
+	return m.evalCompositeOfType(t, lit.Elts)
+}
+
+// evalCompositeOfType builds a composite literal of type t. It is separate from
+// evalComposite because the type of nested literals can be elided, like in
+// [][]int{{1}, {2}}.
+func (m *Machine) evalCompositeOfType(t types.Type, elts []ast.Expr) (*Node, error) {
+	switch t.Kind() {
+	case types.Array:
+		return m.evalArray(t, elts)
+	case types.Map:
+		return m.evalMap(t, elts)
+	case types.Struct:
+		return m.evalStruct(t, elts)
+	default:
+		return nil, errors.Errorf("unsupported composite type %v", t)
+	}
+}
+
+// evalElement evaluates a single element of a composite literal and promotes it
+// to the type t if needed.
+func (m *Machine) evalElement(t types.Type, elt ast.Expr) (*Node, error) {
+	if lit, ok := elt.(*ast.CompositeLit); ok && lit.Type == nil {
+		return m.evalCompositeOfType(t, lit.Elts)
+	}
+
+	node, err := m.Evaluate(elt)
+	if err != nil {
+		return nil, err
+	}
+	return promoteTo(node, t)
+}
+
+// stringToType converts an ast.Ident.Name string into the corresponding type.
+func stringToType(str string) (types.Type, error) {
+	switch str {
+	case "any":
+		return types.AnyType, nil
+	case "comparable":
+		return types.ComparableType, nil
+	case "error":
+		return types.ErrorType, nil
+	case "bool":
+		return types.BoolType, nil
+	case "string":
+		return types.StringType, nil
+	case "float32", "float64":
+		return types.FloatType, nil
+	case "int":
+		return types.IntType, nil
+	case "int8":
+		return types.Int8Type, nil
+	case "int16":
+		return types.Int16Type, nil
+	case "int32", "rune":
+		return types.Int32Type, nil
+	case "int64":
+		return types.Int64Type, nil
+	case "uint":
+		return types.UintType, nil
+	case "uint8", "byte":
+		return types.Uint8Type, nil
+	case "uint16":
+		return types.Uint16Type, nil
+	case "uint32":
+		return types.Uint32Type, nil
+	case "uint64":
+		return types.Uint64Type, nil
+	default:
+		return nil, errors.Errorf("unknown type identifier %s", str)
+	}
+}
+
+// evalType converts an expression describing a type, like []float64, into the
+// corresponding type.
+func (m *Machine) evalType(expr ast.Expr) (types.Type, error) {
+	switch n := expr.(type) {
+	case *ast.Ident:
+		if t, err := stringToType(n.Name); err == nil {
+			return t, nil
+		}
+		node := m.Context.Get(n.Name)
+		if node == nil || node.Type.Kind() != types.TypeName {
+			return nil, errors.Errorf("unknown type identifier %s", n.Name)
+		}
+		if _, ok := node.Value.(*genericType); ok {
+			return nil, errors.Errorf("cannot use generic type %s without instantiation", n.Name)
+		}
+		return node.Value.(types.Type), nil
+	case *ast.SelectorExpr:
+		pkg, ok := n.X.(*ast.Ident)
+		if !ok || packageTypes[pkg.Name][n.Sel.Name] == nil {
+			return nil, errors.Errorf("unknown type %v", n)
+		}
+		return packageTypes[pkg.Name][n.Sel.Name], nil
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		x, indices := splitIndex(n)
+		g, err := m.evalGenericType(x)
+		if err != nil {
+			return nil, err
+		}
+		args, err := m.evalTypeArgs(indices)
+		if err != nil {
+			return nil, err
+		}
+		return m.instantiateType(g, args)
+	case *ast.ArrayType:
+		elemType, err := m.evalType(n.Elt)
+		if err != nil {
+			return nil, err
+		}
+		return types.ArrayOf(elemType), nil
+	case *ast.MapType:
+		keyType, err := m.evalType(n.Key)
+		if err != nil {
+			return nil, err
+		}
+		elemType, err := m.evalType(n.Value)
+		if err != nil {
+			return nil, err
+		}
+		return types.MapOf(keyType, elemType), nil
+	case *ast.StarExpr:
+		elemType, err := m.evalType(n.X)
+		if err != nil {
+			return nil, err
+		}
+		return types.PointerOf(elemType), nil
+	case *ast.ChanType:
+		elemType, err := m.evalType(n.Value)
+		if err != nil {
+			return nil, err
+		}
+		return types.ChanOf(elemType), nil
+	case *ast.StructType:
+		fields := make([]types.Field, 0, n.Fields.NumFields())
+		for _, field := range n.Fields.List {
+			fieldType, err := m.evalType(field.Type)
+			if err != nil {
+				return nil, err
+			}
+
+			names := field.Names
+			if len(names) == 0 {
+				// embedded fields are named after their type
+				names = []*ast.Ident{embeddedFieldName(field.Type)}
+			}
+			for _, name := range names {
+				if slices.ContainsFunc(fields, func(f types.Field) bool {
+					return f.Name == name.Name
+				}) {
+					return nil, errors.Errorf("duplicate field %s", name.Name)
+				}
+				fields = append(fields, types.Field{
+					Name: name.Name,
+					Type: fieldType,
+				})
+			}
+		}
+		return types.StructOf(fields), nil
+	case *ast.InterfaceType:
+		if n.Methods.NumFields() == 0 {
+			return types.AnyType, nil
+		}
+
+		var methods []types.Method
+		var embedded []types.Type
+		seen := map[string]bool{}
+		for _, field := range n.Methods.List {
+			if len(field.Names) == 0 {
+				// embedded interfaces and unions like ~int | float64
+				t, err := m.evalConstraint(field.Type)
+				if err != nil {
+					return nil, err
+				}
+				embedded = append(embedded, t)
+				continue
+			}
+			for _, name := range field.Names {
+				if seen[name.Name] {
+					return nil, errors.Errorf("duplicate method %s", name.Name)
+				}
+				seen[name.Name] = true
+				ft, ok := field.Type.(*ast.FuncType)
+				if !ok {
+					return nil, errors.Errorf("method %s must have a function type", name.Name)
+				}
+				methods = append(methods, signatureOf(name.Name, ft))
+			}
+		}
+		return types.InterfaceOf(methods, embedded), nil
+	case *ast.FuncType:
+		// function types do not have signatures yet
+		return types.FuncType, nil
+	case *ast.ParenExpr:
+		return m.evalType(n.X)
+	default:
+		return nil, errors.Errorf("unsupported type expression %v", reflect.TypeOf(expr))
+	}
+}
+
+func embeddedFieldName(expr ast.Expr) *ast.Ident {
+	switch n := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedFieldName(n.X)
+	case *ast.SelectorExpr:
+		return n.Sel
+	case *ast.Ident:
+		return n
+	default:
+		return &ast.Ident{Name: "_"}
+	}
+}
+
+func (m *Machine) evalArray(t types.Type, elems []ast.Expr) (*Node, error) {
+	elemType, _ := t.Elem()
+	res := make([]*Node, 0, len(elems))
+	for _, elem := range elems {
+		elemNode, err := m.evalElement(elemType, elem)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "array type mismatch", 10)
+		}
+		res = append(res, elemNode)
+	}
+
+	return &Node{
+		Type:  t,
+		Value: res,
+	}, nil
+}
+
+func (m *Machine) evalMap(t types.Type, elems []ast.Expr) (*Node, error) {
+	keyType, _ := t.Key()
+	elemType, _ := t.Elem()
+	res := make(map[any]*mapEntry, len(elems))
+	for _, elem := range elems {
+		kv, ok := elem.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, errors.New("missing key in map literal")
+		}
+
+		keyNode, err := m.evalElement(keyType, kv.Key)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "map key type mismatch", 10)
+		}
+		key, err := mapKey(keyNode)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := res[key]; ok {
+			return nil, errors.Errorf("duplicate key %v in map literal", keyNode.Value)
+		}
+
+		valNode, err := m.evalElement(elemType, kv.Value)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "map value type mismatch", 10)
+		}
+		res[key] = &mapEntry{Key: keyNode, Value: valNode}
+	}
+
+	return &Node{
+		Type:  t,
+		Value: res,
+	}, nil
+}
+
+// evalStruct builds a struct from either a keyed literal like Point{X: 1}, or a
+// positional literal like Point{1, 2}. Fields left out of a keyed literal are
+// set to their zero values.
+func (m *Machine) evalStruct(t types.Type, elems []ast.Expr) (*Node, error) {
+	fields, _ := t.Fields()
+	res := make([]*Node, len(fields))
+
+	keyed := len(elems) > 0
+	if keyed {
+		_, keyed = elems[0].(*ast.KeyValueExpr)
+	}
+	if !keyed && len(elems) > 0 && len(elems) != len(fields) {
+		return nil, errors.Errorf(
+			"wrong number of values in struct literal of type %v, want %d got %d",
+			t,
+			len(fields),
+			len(elems),
+		)
+	}
+
+	for i, elem := range elems {
+		kv, isKv := elem.(*ast.KeyValueExpr)
+		if isKv != keyed {
+			return nil, errors.New(
+				"mixture of field:value and value elements in struct literal",
+			)
+		}
+
+		index := i
+		if keyed {
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				return nil, errors.Errorf("invalid field name %v in struct literal", kv.Key)
+			}
+			var err error
+			index, err = fieldIndex(t, key.Name)
+			if err != nil {
+				return nil, err
+			}
+			if res[index] != nil {
+				return nil, errors.Errorf("duplicate field name %s in struct literal", key.Name)
+			}
+			elem = kv.Value
+		}
+
+		node, err := m.evalElement(fields[index].Type, elem)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "struct field type mismatch", 10)
+		}
+		res[index] = node
+	}
+
+	for i, field := range fields {
+		if res[i] == nil {
+			res[i] = zeroValue(field.Type)
+		}
+	}
+
+	return &Node{
+		Type:  t,
+		Value: res,
+	}, nil
+}
+
+func (m *Machine) evalIf(n *ast.IfStmt) (*Node, error) {
+	// save machine context
+	oldContext := m.Context
+	// context for the stuff in (...)
+	ifContext := oldContext.NewChildContext("if stmt")
+	m.Context = ifContext
+
+	if n.Init != nil {
+		_, err := m.Evaluate(n.Init)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cond, err := m.Evaluate(n.Cond)
+	if err != nil {
+		return nil, err
+	}
+
+	// context for the stuff in the if block
+	blockContext := ifContext.NewChildContext("if block")
+	m.Context = blockContext
+	var res *Node
+	if cond.Type.Kind() != types.Bool {
+		return nil, errors.New("if condition evaluated to a non-boolean")
+	}
+
+	if cond.Value.(bool) {
+		res, err = m.Evaluate(n.Body)
+	} else if n.Else != nil {
+		res, err = m.Evaluate(n.Else)
+	}
+
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "cannot eval if", 10)
+	}
+
+	m.Context = oldContext
+	return res, nil
+}
+
+func (m *Machine) evalSwitch(n *ast.SwitchStmt, label string) (*Node, error) {
+	// save machine context
+	oldContext := m.Context
+	// context for the stuff in (...)
+	switchContext := oldContext.NewChildContext("switch stmt")
+	m.Context = switchContext
+	defer func() {
+		m.Context = oldContext
+	}()
+
+	if n.Init != nil {
+		_, err := m.Evaluate(n.Init)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a switch without a tag is the same as switch true
+	var tag *Node
+	if n.Tag != nil {
+		var err error
+		tag, err = m.Evaluate(n.Tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matched := -1
+	defaultClause := -1
+clauses:
+	for i, stmt := range n.Body.List {
+		clause := stmt.(*ast.CaseClause)
+		if clause.List == nil {
+			defaultClause = i
+			continue
+		}
+
+		for _, expr := range clause.List {
+			val, err := m.Evaluate(expr)
+			if err != nil {
+				return nil, err
+			}
+			ok, err := caseMatches(tag, val)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matched = i
+				break clauses
+			}
+		}
+	}
+	if matched == -1 {
+		matched = defaultClause
+	}
+	if matched == -1 {
+		return nil, nil
+	}
+
+	res, err := m.evalCaseClauses(n.Body.List, matched, switchContext)
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "cannot eval switch", 10)
+	}
+	if res != nil && res.IsBreak && (res.Label == "" || res.Label == label) {
+		// the break is for this switch
+		return nil, nil
+	}
+	return res, nil
+}
+
+func caseMatches(tag *Node, val *Node) (bool, error) {
+	if tag != nil {
+		var err error
+		val, err = binaryOp(token.EQL, tag, val)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if val.Type.Kind() != types.Bool {
+		return false, errors.New("switch case evaluated to a non-boolean")
+	}
+	return val.Value.(bool), nil
+}
+
+// evalCaseClauses runs the body of the i-th clause, continuing with the
+// following clauses for as long as they end with a fallthrough.
+func (m *Machine) evalCaseClauses(clauses []ast.Stmt, i int, switchContext *context) (*Node, error) {
+	for ; i < len(clauses); i++ {
+		body := clauses[i].(*ast.CaseClause).Body
+
+		fallthrough_ := false
+		if len(body) > 0 {
+			last, ok := body[len(body)-1].(*ast.BranchStmt)
+			if ok && last.Tok == token.FALLTHROUGH {
+				if i == len(clauses)-1 {
+					return nil, errors.New("cannot fallthrough final case in switch")
+				}
+				fallthrough_ = true
+				body = body[:len(body)-1]
+			}
+		}
+
+		// context for the stuff in each case
+		m.Context = switchContext.NewChildContext("case block")
+		res, err := m.Evaluate(&ast.BlockStmt{List: body})
+		if err != nil {
+			return nil, err
+		}
+		if !fallthrough_ || res.interrupts() {
+			return res, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *Machine) evalTypeSwitch(n *ast.TypeSwitchStmt, label string) (*Node, error) {
+	// save machine context
+	oldContext := m.Context
+	// context for the stuff in (...)
+	switchContext := oldContext.NewChildContext("switch stmt")
+	m.Context = switchContext
+	defer func() {
+		m.Context = oldContext
+	}()
+
+	if n.Init != nil {
+		_, err := m.Evaluate(n.Init)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the guard is either x.(type) or v := x.(type)
+	var name string
+	var assert *ast.TypeAssertExpr
+	switch guard := n.Assign.(type) {
+	case *ast.AssignStmt:
+		name = guard.Lhs[0].(*ast.Ident).Name
+		assert = guard.Rhs[0].(*ast.TypeAssertExpr)
+	case *ast.ExprStmt:
+		assert = guard.X.(*ast.TypeAssertExpr)
+	}
+	xNode, err := m.Evaluate(assert.X)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched *ast.CaseClause
+	var matchedType types.Type
+	var defaultClause *ast.CaseClause
+clauses:
+	for _, stmt := range n.Body.List {
+		clause := stmt.(*ast.CaseClause)
+		if clause.List == nil {
+			defaultClause = clause
+			continue
+		}
+
+		for _, expr := range clause.List {
+			if ident, ok := expr.(*ast.Ident); ok && ident.Name == "nil" {
+				if xNode.Type.Kind() == types.Interface {
+					matched = clause
+					break clauses
+				}
+				continue
+			}
+
+			t, err := m.evalType(expr)
+			if err != nil {
+				return nil, err
+			}
+			if hasType(xNode, t) {
+				matched = clause
+				matchedType = t
+				break clauses
+			}
+		}
+	}
+	if matched == nil {
+		matched = defaultClause
+	}
+	if matched == nil {
+		return nil, nil
+	}
+
+	// context for the stuff in the case
+	m.Context = switchContext.NewChildContext("case block")
+	if name != "" {
+		// the name has the type of the case if it only has one
+		if len(matched.List) == 1 && matchedType != nil {
+			xNode = assertedValue(xNode, matchedType)
+		}
+		m.Context.Set(name, xNode)
+	}
+	res, err := m.Evaluate(&ast.BlockStmt{List: matched.Body})
+	if err != nil {
+		return nil, errors.WrapPrefix(err, "cannot eval type switch", 10)
+	}
+	if res != nil && res.IsBreak && (res.Label == "" || res.Label == label) {
+		// the break is for this switch
+		return nil, nil
+	}
+	return res, nil
+}
+
+func (m *Machine) evalRange(expr *ast.RangeStmt, label string) (*Node, error) {
+	// save context before for block
+	oldContext := m.Context
+	// context for the contents in the (...).
+	forContext := oldContext.NewChildContext("for stmt")
+
+	m.Context = forContext
+	rangeTarget, err := m.Evaluate(expr.X)
+	if err != nil {
+		return nil, err
+	}
+
+	var res *Node
+	// iterate runs the body once with the given key and value, and reports
+	// whether the loop should stop.
+	iterate := func(key, value *Node) (bool, error) {
+		m.Context = forContext
+		if expr.Key != nil {
+			name := expr.Key.(*ast.Ident).Name
+			m.Context.Set(name, key)
+		}
+		if expr.Value != nil {
+			name := expr.Value.(*ast.Ident).Name
+			m.Context.Set(name, value)
+		}
+
+		// every iteration gets a new context for the for block, so that
+		// variables declared in it are new variables each time
+		m.Context = forContext.NewChildContext("for block")
+		body, err := m.Evaluate(expr.Body)
+		if err != nil {
+			return true, errors.WrapPrefix(err, "cannot eval range body", 10)
+		}
+
+		var stop bool
+		stop, res = loopControl(body, label)
+		return stop, nil
+	}
+
+	switch rangeTarget.Type.Kind() {
+	case types.Array:
+		arr := rangeTarget.Value.([]*Node)
+		for i, elem := range arr {
+			key, _ := ValueToNode(i)
+			stop, err := iterate(key, elem)
+			if err != nil {
+				return nil, err
+			}
+			if stop {
+				break
+			}
+		}
+	case types.Map:
+		for _, entry := range rangeTarget.Value.(map[any]*mapEntry) {
+			stop, err := iterate(entry.Key, entry.Value)
+			if err != nil {
+				return nil, err
+			}
+			if stop {
+				break
+			}
+		}
+	case types.String:
+		// strings are ranged over by runes, with the keys being byte
+		// offsets
+		for i, r := range rangeTarget.Value.(string) {
+			stop, err := iterate(
+				NewIntNode(int64(i)),
+				&Node{Type: types.Int32Type, Value: int64(r)},
+			)
+			if err != nil {
+				return nil, err
+			}
+			if stop {
+				break
+			}
+		}
+	case types.Chan:
+		for {
+			val, ok, err := m.recv(rangeTarget)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			stop, err := iterate(val, nil)
+			if err != nil {
+				return nil, err
+			}
+			if stop {
+				break
+			}
+		}
+	default:
+		return nil, errors.Errorf("range not implemented for type %v", rangeTarget.Type)
+	}
+
+	m.Context = oldContext
+	return res, nil
+}
+
+func (m *Machine) evalBranch(n *ast.BranchStmt) (*Node, error) {
+	var label string
+	if n.Label != nil {
+		label = n.Label.Name
+	}
+
+	switch n.Tok {
+	case token.BREAK:
+		return &Node{IsBreak: true, Label: label}, nil
+	case token.CONTINUE:
+		return &Node{IsContinue: true, Label: label}, nil
+	case token.GOTO:
+		return &Node{IsGoto: true, Label: label}, nil
+	case token.FALLTHROUGH:
+		// fallthroughs in the right place are dealt with by evalSwitch
+		return nil, errors.New("fallthrough statement out of place")
+	default:
+		return nil, errors.Errorf("unimplemented branch token %v", n.Tok)
+	}
+}
+
+func (m *Machine) evalLabeled(n *ast.LabeledStmt) (*Node, error) {
+	label := n.Label.Name
+	switch stmt := n.Stmt.(type) {
+	case *ast.ForStmt:
+		return m.evalFor(stmt, label)
+	case *ast.RangeStmt:
+		return m.evalRange(stmt, label)
+	case *ast.SwitchStmt:
+		return m.evalSwitch(stmt, label)
+	case *ast.TypeSwitchStmt:
+		return m.evalTypeSwitch(stmt, label)
+	}
+
+	res, err := m.Evaluate(n.Stmt)
+	if err != nil {
+		return nil, err
+	}
+	if res != nil && res.IsBreak && res.Label == label {
+		return nil, errors.Errorf("invalid break label %s", label)
+	}
+	return res, nil
+}
+
+// checkBranchTarget returns an error if res is a branch that did not find the
+// statement it refers to before reaching the end of the function.
+func checkBranchTarget(res *Node) error {
+	switch {
+	case res == nil || res.Label == "" && !res.IsBreak && !res.IsContinue:
+		return nil
+	case res.Label != "":
+		return errors.Errorf("label %s not defined", res.Label)
+	case res.IsBreak:
+		return errors.New("break is not in a loop, switch, or select")
+	default:
+		return errors.New("continue is not in a loop")
+	}
+}
+
+// loopControl works out what a loop with the given label should do after its
+// body evaluates to res. It reports whether the loop should stop, and what the
+// loop evaluates to.
+func loopControl(res *Node, label string) (bool, *Node) {
+	if !res.interrupts() {
+		return false, nil
+	}
+
+	ours := res.Label == "" || res.Label == label
+	switch {
+	case res.IsBreak && ours:
+		return true, nil
+	case res.IsContinue && ours:
+		return false, nil
+	default:
+		// returns, gotos and branches to outer statements
+		return true, res
+	}
+}
+
+func (m *Machine) evalFor(n *ast.ForStmt, label string) (*Node, error) {
+	// save context before for block
+	oldContext := m.Context
+	// context for the contents in the (...).
+	forContext := oldContext.NewChildContext("for stmt")
+
+	m.Context = forContext
+	defer func() {
+		m.Context = oldContext
+	}()
+
+	if n.Init != nil {
+		_, err := m.Evaluate(n.Init)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "cannot eval for init block", 10)
+		}
+	}
+
+	for {
+		// a missing condition is the same as true
+		if n.Cond != nil {
+			cond, err := m.Evaluate(n.Cond)
+			if err != nil {
+				return nil, errors.WrapPrefix(err, "cannot eval for cond block", 10)
+			}
+			if cond.Type.Kind() != types.Bool {
+				return nil, errors.New("for condition evaluated to a non-boolean")
+			}
+			if !(cond.Value.(bool)) {
+				break
+			}
+		}
+
+		// every iteration gets a new context for the for block, so that
+		// variables declared in it are new variables each time
+		m.Context = forContext.NewChildContext("for block")
+		body, err := m.Evaluate(n.Body)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "cannot eval for body", 10)
+		}
+
+		stop, res := loopControl(body, label)
+		if stop {
+			return res, nil
+		}
+
+		m.Context = forContext
+		if n.Post != nil {
+			_, err = m.Evaluate(n.Post)
+			if err != nil {
+				return nil, errors.WrapPrefix(err, "cannot eval for post", 10)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// applyFunction calls fun with args. If spread is true, the last argument is an
+// array that is passed as is to the variadic parameter, like in f(a, b...).
+func (m *Machine) applyFunction(fun *Node, args []*Node, spread bool) (*Node, error) {
+	oldContext := m.Context
+	// functions run in the context they were defined in, not the one they
+	// are called from
+	defContext := fun.Context
+	if defContext == nil {
+		defContext = m.Context
+	}
+	funcContext := defContext.NewChildContext("func block")
+	m.Context = funcContext
+	f := &frame{parent: m.frame}
+	m.frame = f
+	defer func() {
+		m.frame = f.parent
+		m.Context = oldContext
+	}()
+
+	n := fun.Value.(*ast.FuncLit)
+	err := m.bindParams(n.Type.Params.List, args, spread)
+	if err != nil {
+		return nil, err
+	}
+	err = m.declareResults(n.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	// evaluate body
+	res, err := m.Evaluate(n.Body)
+	// an error might have left us in some inner block
+	m.Context = funcContext
+	if err == nil {
+		err = checkBranchTarget(res)
+	}
+	if err == nil && n.Type.Results != nil {
+		res, err = m.checkResults(n.Type, res)
+	}
+	if res != nil && res.IsReturnValue {
+		// the return stops at this function
+		ret := *res
+		ret.IsReturnValue = false
+		res = &ret
+		if res.Type == nil {
+			// a return without values
+			res = nil
+		}
+	}
+	return m.runDefers(f, n.Type, res, err)
+}
+
+// bindParams declares the parameters of a function in the current context.
+// Trailing arguments for a variadic parameter are collected into an array.
+func (m *Machine) bindParams(params []*ast.Field, args []*Node, spread bool) error {
+	fixed := len(params)
+	variadic := fixed > 0 && isVariadic(params[fixed-1])
+	if variadic {
+		fixed--
+	}
+
+	switch {
+	case spread && !variadic:
+		return errors.New("cannot use ... in call to non-variadic function")
+	case len(args) < fixed || spread && len(args) == fixed:
+		return errors.Errorf(
+			"not enough arguments to function, have %d want %d",
+			len(args),
+			len(params),
+		)
+	case !variadic && len(args) > fixed || spread && len(args) > len(params):
+		return errors.Errorf(
+			"too many arguments to function, have %d want %d",
+			len(args),
+			len(params),
+		)
+	}
+
+	for i, param := range params[:fixed] {
+		arg := args[i]
+		if param.Type != nil {
+			t, err := m.evalType(param.Type)
+			if err != nil {
+				return err
+			}
+			arg, err = promoteTo(arg, t)
+			if err != nil {
+				return errors.WrapPrefix(err, "in argument "+param.Names[0].Name, 10)
+			}
+		} else if err := checkConst(arg); err != nil {
+			return errors.WrapPrefix(err, "in argument "+param.Names[0].Name, 10)
+		}
+		m.Context.Set(param.Names[0].Name, arg)
+	}
+	if !variadic {
+		return nil
 	}
 
+	param := params[fixed]
+	elemType, err := m.evalType(param.Type.(*ast.Ellipsis).Elt)
 	if err != nil {
-		return nil, errors.WrapPrefix(err, "cannot eval if", 10)
+		return err
 	}
+	arrType := types.ArrayOf(elemType)
 
-	m.Context = oldContext
-	return res, nil
+	var rest *Node
+	if spread {
+		rest, err = promoteTo(args[fixed], arrType)
+		if err != nil {
+			return errors.WrapPrefix(err, "in argument "+param.Names[0].Name, 10)
+		}
+	} else {
+		var elems []*Node
+		for _, arg := range args[fixed:] {
+			elem, err := promoteTo(arg, elemType)
+			if err != nil {
+				return errors.WrapPrefix(err, "in argument "+param.Names[0].Name, 10)
+			}
+			elems = append(elems, elem)
+		}
+		rest = &Node{
+			Type:  arrType,
+			Value: elems,
+		}
+	}
+	m.Context.Set(param.Names[0].Name, rest)
+	return nil
 }
 
-func (m *Machine) evalRange(expr *ast.RangeStmt) (*Node, error) {
-	// save context before for block
-	oldContext := m.Context
-	// context for the contents in the (...).
-	forContext := oldContext.NewChildContext("for stmt")
-	// context for the for block
-	blockContext := forContext.NewChildContext("for block")
-
-	m.Context = forContext
-	rangeTarget, err := m.Evaluate(expr.X)
+// checkResults checks the values returned by a function against its declared
+// result types, promoting them if needed.
+func (m *Machine) checkResults(ft *ast.FuncType, res *Node) (*Node, error) {
+	resultTypes, err := m.resultTypes(ft)
 	if err != nil {
 		return nil, err
 	}
+	if res == nil || !res.IsReturnValue {
+		if len(resultTypes) == 0 {
+			return res, nil
+		}
+		return nil, errors.New("missing return")
+	}
 
-	var res *Node
-	switch rangeTarget.Type.Kind() {
-	case types.Array:
-		arr := rangeTarget.Value.([]*Node)
-		for i, elem := range arr {
-			m.Context = forContext
-			if expr.Key != nil {
-				val, _ := ValueToNode(i)
-				name := expr.Key.(*ast.Ident).Name
-				m.Context.Set(name, val)
-			}
-			if expr.Value != nil {
-				name := expr.Value.(*ast.Ident).Name
-				m.Context.Set(name, elem)
-			}
+	names := resultNames(ft)
+	if res.Type == nil && names != nil {
+		// a bare return returns the named results as they are
+		return m.loadResults(names), nil
+	}
 
-			m.Context = blockContext
-			res, err = m.Evaluate(expr.Body)
-			if err != nil {
-				return nil, errors.WrapPrefix(err, "cannot eval range body", 10)
-			}
+	var results []*Node
+	switch {
+	case res.Type == nil:
+	case res.Type.Kind() == types.Packing:
+		results = res.Elems
+	default:
+		results = []*Node{res}
+	}
+	if len(results) != len(resultTypes) {
+		return nil, errors.Errorf(
+			"wrong number of return values, have %d want %d",
+			len(results),
+			len(resultTypes),
+		)
+	}
 
-			if res != nil && res.IsReturnValue {
-				break
-			}
+	promoted := make([]*Node, len(results))
+	for i, result := range results {
+		promoted[i], err = promoteTo(result, resultTypes[i])
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "in return value", 10)
+		}
+		if names != nil {
+			// deferred calls can see what is returned through the
+			// named results
+			m.Context.Update(names[i], promoted[i])
 		}
-	default:
-		return nil, errors.Errorf("range not implemented for type %v", rangeTarget.Type)
 	}
+	return packResults(promoted), nil
+}
 
-	m.Context = oldContext
-	return res, nil
+func isVariadic(param *ast.Field) bool {
+	_, ok := param.Type.(*ast.Ellipsis)
+	return ok
 }
 
-func (m *Machine) evalBranch(n *ast.BranchStmt) (*Node, error) {
-	switch n.Tok {
-	case token.CONTINUE:
-		return &Node{IsContinue: true}, nil
-	default:
-		return nil, errors.Errorf("unimplemented branch token %v", n.Tok)
+// spreadArgs expands the array in the last argument of f(a, b...) into separate
+// arguments.
+func spreadArgs(args []*Node) ([]*Node, error) {
+	if len(args) == 0 {
+		return nil, errors.New("missing argument for ...")
 	}
+	last := args[len(args)-1]
+	if last.Type.Kind() != types.Array {
+		return nil, errors.Errorf("cannot use ... with %v", last.Type)
+	}
+	return append(args[:len(args)-1:len(args)-1], last.Value.([]*Node)...), nil
 }
 
-func (m *Machine) evalFor(n *ast.ForStmt) (*Node, error) {
-	// save context before for block
-	oldContext := m.Context
-	// context for the contents in the (...).
-	forContext := oldContext.NewChildContext("for stmt")
-	// context for the for block
-	blockContext := forContext.NewChildContext("for block")
+func (m *Machine) evalFunctionCall(call *ast.CallExpr) (*Node, error) {
+	if m.isType(call.Fun) {
+		return m.evalConversion(call)
+	}
 
-	m.Context = forContext
-	_, err := m.Evaluate(n.Init)
+	funNode, typeArgs, err := m.evalCallee(call.Fun)
 	if err != nil {
-		return nil, errors.WrapPrefix(err, "cannot eval for init block", 10)
+		return nil, err
 	}
 
-	var res *Node
-	for {
-		cond, err := m.Evaluate(n.Cond)
-		if err != nil {
-			return nil, errors.WrapPrefix(err, "cannot eval for cond block", 10)
-		}
-		if cond.Type.Kind() != types.Bool {
-			return nil, errors.New("for condition evaluated to a non-boolean")
+	// f(a, b...)
+	spread := call.Ellipsis.IsValid()
+	switch funNode.Type.Kind() {
+	case types.Builtin:
+		if spread {
+			return m.callBuiltinSpread(funNode, call.Args)
 		}
-		if !(cond.Value.(bool)) {
-			break
+		return m.CallBuiltin(funNode, call.Args)
+	case types.Func:
+		if funNode.Value == nil {
+			return nil, runtimePanic("runtime error: invalid memory address or nil pointer dereference")
 		}
+	default:
+		return nil, errors.Errorf("cannot call non-function of type %v", funNode.Type)
+	}
 
-		m.Context = blockContext
-		res, err = m.Evaluate(n.Body)
+	nodeArgs, err := m.evalArgs(call.Args)
+	if err != nil {
+		return nil, err
+	}
+	if isGeneric(funNode) {
+		funNode, err = m.instantiateCall(funNode, typeArgs, nodeArgs, spread)
 		if err != nil {
-			return nil, errors.WrapPrefix(err, "cannot eval for body", 10)
+			return nil, err
 		}
+	}
 
-		if res != nil {
-			if res.IsReturnValue {
-				break
-			}
-		}
+	return m.applyFunction(funNode, nodeArgs, spread)
+}
 
-		m.Context = forContext
-		if n.Post != nil {
-			_, err = m.Evaluate(n.Post)
-			if err != nil {
-				return nil, errors.WrapPrefix(err, "cannot eval for post", 10)
-			}
+// isType reports whether expr names a type rather than a value, like the int in
+// int(x) or the []byte in []byte(s).
+func (m *Machine) isType(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if node := m.Context.Get(e.Name); node != nil {
+			return node.Type.Kind() == types.TypeName
 		}
+		_, err := stringToType(e.Name)
+		return err == nil
+	case *ast.ParenExpr:
+		return m.isType(e.X)
+	case *ast.StarExpr:
+		// (*T)(x)
+		return m.isType(e.X)
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		// instances of generic types like Stack[int](x)
+		x, _ := splitIndex(e)
+		return m.isType(x)
+	case *ast.SelectorExpr:
+		pkg, ok := e.X.(*ast.Ident)
+		return ok && m.Context.Get(pkg.Name) == nil &&
+			packageTypes[pkg.Name][e.Sel.Name] != nil
+	case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType,
+		*ast.InterfaceType, *ast.StructType:
+		return true
+	default:
+		return false
 	}
-
-	m.Context = oldContext
-	return res, nil
 }
 
-func (m *Machine) applyFunction(fun *Node, args []*Node) (*Node, error) {
-	m.Context = m.Context.NewChildContext("func block")
-	var err error
-
-	n := fun.Value.(*ast.FuncLit)
-	// populate arguments
-	fieldList := n.Type.Params.List
-
+// evalConversion evaluates a conversion like float64(x).
+func (m *Machine) evalConversion(call *ast.CallExpr) (*Node, error) {
+	t, err := m.evalType(call.Fun)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(fieldList) > len(args) {
-		return nil, errors.New("not enough arguments to function")
+	if len(call.Args) != 1 || call.Ellipsis.IsValid() {
+		return nil, errors.Errorf("conversion to %v needs exactly one argument", t)
 	}
-
-	for i, param := range fieldList {
-		paramName := param.Names[0].Name
-		if err != nil {
-			return nil, errors.WrapPrefix(err, "cannot eval arg", 10)
-		}
-		m.Context.Set(paramName, args[i])
+	if types.IsConstraint(t) {
+		return nil, errors.Errorf("cannot use %v outside a type constraint", t)
 	}
 
-	// evaluate body
-	var res *Node
-	res, err = m.Evaluate(n.Body)
+	node, err := m.Evaluate(call.Args[0])
 	if err != nil {
 		return nil, err
 	}
-
-	m.Context = m.Context.Parent
-	return res, nil
+	if node == nil {
+		return nil, errNoValue
+	}
+	return convertTo(node, t)
 }
 
-func (m *Machine) evalFunctionCall(fun ast.Expr, args []ast.Expr) (*Node, error) {
-	funNode, err := m.Evaluate(fun)
-	if err != nil {
-		return nil, err
+// evalCallee evaluates the function in a call. For generic functions the type
+// arguments given like in f[int](x) are returned separately, so that the rest
+// can be inferred from the arguments.
+func (m *Machine) evalCallee(expr ast.Expr) (*Node, []types.Type, error) {
+	x, indices := splitIndex(expr)
+	if x == nil {
+		funNode, err := m.Evaluate(expr)
+		return funNode, nil, err
 	}
 
-	if funNode.Type.Kind() == types.Builtin {
-		return m.CallBuiltin(funNode, args)
+	xNode, err := m.Evaluate(x)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isGeneric(xNode) {
+		funNode, err := m.indexNode(xNode, indices)
+		return funNode, nil, err
 	}
+	typeArgs, err := m.evalTypeArgs(indices)
+	return xNode, typeArgs, err
+}
 
+func (m *Machine) evalArgs(args []ast.Expr) ([]*Node, error) {
 	nodeArgs := make([]*Node, len(args))
 	for i, arg := range args {
 		n, err := m.Evaluate(arg)
@@ -599,24 +2195,45 @@ func (m *Machine) evalFunctionCall(fun ast.Expr, args []ast.Expr) (*Node, error)
 		nodeArgs[i] = n
 	}
 
-	return m.applyFunction(funNode, nodeArgs)
+	return nodeArgs, nil
 }
 
 func (m *Machine) evalUnary(expr *ast.UnaryExpr) (*Node, error) {
+	if expr.Op == token.AND {
+		return m.evalAddress(expr.X)
+	}
+
 	node, err := m.Evaluate(expr.X)
 	if err != nil {
 		return nil, err
 	}
+	if expr.Op == token.ARROW {
+		val, _, err := m.recv(node)
+		return val, err
+	}
+
+	if node.untyped && (expr.Op == token.SUB || expr.Op == token.XOR) &&
+		node.Type.Kind() == types.Int {
+		return constUnary(expr.Op, node)
+	}
+	if node.constant && (expr.Op == token.SUB ||
+		expr.Op == token.XOR && node.Type.Kind() == types.Int) {
+		res, err := constUnary(expr.Op, node)
+		if err != nil {
+			return nil, err
+		}
+		return typedConst(res, node.Type)
+	}
 
 	switch expr.Op {
 	case token.SUB:
 		switch node.Type.Kind() {
 		case types.Float:
-			return NewFloatNode(-node.Value.(float64)), nil
+			return &Node{Type: node.Type, Value: -node.Value.(float64)}, nil
 		case types.Int:
-			return NewIntNode(-node.Value.(int64)), nil
+			return newIntegerNode(node.Type, -node.Value.(int64)), nil
 		case types.Uint:
-			return NewUintNode(-node.Value.(uint64)), nil
+			return newIntegerNode(node.Type, -node.Value.(uint64)), nil
 		default:
 			return nil, errors.Errorf("unsupported operand types %v", node.Type)
 		}
@@ -625,6 +2242,15 @@ func (m *Machine) evalUnary(expr *ast.UnaryExpr) (*Node, error) {
 			return nil, errors.Errorf("unsupported operand types %v", node.Type)
 		}
 		return NewBoolNode(!node.Value.(bool)), nil
+	case token.XOR:
+		switch node.Type.Kind() {
+		case types.Int:
+			return newIntegerNode(node.Type, ^node.Value.(int64)), nil
+		case types.Uint:
+			return newIntegerNode(node.Type, ^node.Value.(uint64)), nil
+		default:
+			return nil, errors.Errorf("operator ^ not defined on %v", node.Type)
+		}
 	default:
 		return nil, errors.New("operation not supported")
 	}
@@ -712,12 +2338,34 @@ func binop[T Numeric](op token.Token, operand1, operand2 T) T {
 	}
 }
 
-func bincomp[T Numeric](op token.Token, operand1, operand2 T) bool {
+func bitop[T constraints.Integer](op token.Token, operand1, operand2 T) T {
+	switch op {
+	case token.AND: // &
+		return operand1 & operand2
+	case token.OR: // |
+		return operand1 | operand2
+	case token.XOR: // ^
+		return operand1 ^ operand2
+	case token.AND_NOT: // &^
+		return operand1 &^ operand2
+	default:
+		return 0
+	}
+}
+
+func shift[T constraints.Integer](op token.Token, operand T, count uint64) T {
+	if op == token.SHL { // <<
+		return operand << count
+	}
+	return operand >> count // >>
+}
+
+func bincomp[T cmp.Ordered](op token.Token, operand1, operand2 T) bool {
 	switch op {
 	case token.GTR: // >
 		return operand1 > operand2
-	case token.GEQ: // >
-		return operand1 > operand2
+	case token.GEQ: // >=
+		return operand1 >= operand2
 	case token.LSS: // <
 		return operand1 < operand2
 	case token.LEQ: // <=
@@ -745,7 +2393,27 @@ func (m *Machine) evalBinary(expr *ast.BinaryExpr) (*Node, error) {
 		return nil, err
 	}
 
-	if !nodeX.Type.Kind().IsNumeric() || !nodeY.Type.Kind().IsNumeric() {
+	return binaryOp(expr.Op, nodeX, nodeY)
+}
+
+// binaryOp applies a binary operator to two evaluated operands.
+func binaryOp(op token.Token, nodeX, nodeY *Node) (*Node, error) {
+	kindX := nodeX.Type.Kind()
+	kindY := nodeY.Type.Kind()
+	if (nodeX.boxed || nodeY.boxed) && (op == token.EQL || op == token.NEQ) {
+		return equalityOp(op, nodeX, nodeY)
+	}
+	if kindX == types.String && kindY == types.String {
+		return stringOp(op, nodeX, nodeY)
+	}
+
+	if !kindX.IsNumeric() || !kindY.IsNumeric() {
+		if op == token.EQL || op == token.NEQ {
+			return equalityOp(op, nodeX, nodeY)
+		}
+		if kindX == kindY {
+			return nil, errors.Errorf("operator %v not defined on %v", op, nodeX.Type)
+		}
 		return nil, errors.Errorf(
 			"unsupported operand type %v %v",
 			nodeX.Type,
@@ -753,56 +2421,274 @@ func (m *Machine) evalBinary(expr *ast.BinaryExpr) (*Node, error) {
 		)
 	}
 
-	switch expr.Op {
+	if op == token.SHL || op == token.SHR {
+		return shiftOp(op, nodeX, nodeY)
+	}
+	if nodeX.Type.Kind() == types.Float || nodeY.Type.Kind() == types.Float {
+		return floatOp(op, nodeX, nodeY)
+	}
+	return integerOp(op, nodeX, nodeY)
+}
+
+func floatOp(op token.Token, nodeX, nodeY *Node) (*Node, error) {
+	operand1, err := nodeX.ToFloat()
+	if err != nil {
+		return nil, err
+	}
+	operand2, err := nodeY.ToFloat()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
 	case token.ADD, token.SUB, token.MUL, token.QUO, token.REM:
-		if nodeX.Type.Kind() == types.Float || nodeY.Type.Kind() == types.Float {
-			operand1, err := nodeX.ToFloat()
-			if err != nil {
-				return nil, err
-			}
-			operand2, err := nodeY.ToFloat()
-			if err != nil {
-				return nil, err
-			}
-			return NewFloatNode(binop(expr.Op, operand1, operand2)), nil
-		} else if nodeX.Type.Kind() == types.Int && nodeY.Type.Kind() == types.Int {
-			operand1 := nodeX.Value.(int64)
-			operand2 := nodeY.Value.(int64)
-			return NewIntNode(binop(expr.Op, operand1, operand2)), nil
-		} else {
-			return nil, errors.Errorf("unsupported types %v %v", nodeX.Type, nodeY.Type)
+		// the result has the type of the float operand, which might be
+		// a named type
+		t := nodeX.Type
+		if t.Kind() != types.Float {
+			t = nodeY.Type
 		}
+		return &Node{Type: t, Value: binop(op, operand1, operand2)}, nil
 	case token.GTR, token.GEQ, token.LSS, token.LEQ, token.EQL, token.NEQ:
-		if nodeX.Type.Kind() == types.Float || nodeY.Type.Kind() == types.Float {
-			operand1, err := nodeX.ToFloat()
-			if err != nil {
-				return nil, err
-			}
-			operand2, err := nodeY.ToFloat()
-			if err != nil {
-				return nil, err
+		return NewBoolNode(bincomp(op, operand1, operand2)), nil
+	default:
+		return nil, errors.Errorf("operator %v not defined on float", op)
+	}
+}
+
+func integerOp(op token.Token, nodeX, nodeY *Node) (*Node, error) {
+	// operations on constants give exact constants
+	if nodeX.untyped && nodeY.untyped {
+		return constOp(op, nodeX, nodeY)
+	}
+	constant := isConst(nodeX) && isConst(nodeY)
+	nodeX, nodeY, err := matchIntegers(nodeX, nodeY)
+	if err != nil {
+		return nil, err
+	}
+	if constant {
+		res, err := constOp(op, nodeX, nodeY)
+		if err != nil {
+			return nil, err
+		}
+		return typedConst(res, nodeX.Type)
+	}
+
+	if nodeX.Type.Kind() == types.Uint {
+		return intop(op, nodeX.Type, nodeX.Value.(uint64), nodeY.Value.(uint64))
+	}
+	return intop(op, nodeX.Type, nodeX.Value.(int64), nodeY.Value.(int64))
+}
+
+// matchIntegers converts untyped constant operands to the type of the other
+// operand. Typed operands must have the same type.
+func matchIntegers(nodeX, nodeY *Node) (*Node, *Node, error) {
+	var err error
+	switch {
+	case nodeX.untyped:
+		nodeX, err = convertConst(nodeX, nodeY.Type)
+	case nodeY.untyped:
+		nodeY, err = convertConst(nodeY, nodeX.Type)
+	case !nodeX.Type.Equal(nodeY.Type):
+		err = errors.Errorf("mismatched types %v and %v", nodeX.Type, nodeY.Type)
+	}
+
+	return nodeX, nodeY, err
+}
+
+func intop[T int64 | uint64](op token.Token, t types.Type, operand1, operand2 T) (*Node, error) {
+	switch op {
+	case token.ADD, token.SUB, token.MUL:
+		return newIntegerNode(t, binop(op, operand1, operand2)), nil
+	case token.QUO, token.REM:
+		if operand2 == 0 {
+			return nil, runtimePanic("runtime error: integer divide by zero")
+		}
+		if op == token.QUO {
+			return newIntegerNode(t, operand1/operand2), nil
+		}
+		return newIntegerNode(t, operand1%operand2), nil
+	case token.AND, token.OR, token.XOR, token.AND_NOT:
+		return newIntegerNode(t, bitop(op, operand1, operand2)), nil
+	case token.GTR, token.GEQ, token.LSS, token.LEQ, token.EQL, token.NEQ:
+		return NewBoolNode(bincomp(op, operand1, operand2)), nil
+	default:
+		return nil, errors.Errorf("operator %v not defined on %v", op, t)
+	}
+}
+
+// equalityOp compares two values that are not numbers with == or !=.
+func equalityOp(op token.Token, nodeX, nodeY *Node) (*Node, error) {
+	if isUntypedNil(nodeX) || isUntypedNil(nodeY) {
+		return nilEqualityOp(op, nodeX, nodeY)
+	}
+	for _, node := range []*Node{nodeX, nodeY} {
+		if !types.Comparable(node.Type) {
+			return nil, errors.Errorf("%v is not comparable", node.Type)
+		}
+	}
+
+	boxed := nodeX.boxed || nodeY.boxed
+	if boxed {
+		// constants compared with an interface are stored in one first
+		var err error
+		nodeX, err = defaultType(nodeX)
+		if err != nil {
+			return nil, err
+		}
+		nodeY, err = defaultType(nodeY)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// interfaces can be compared with anything, and are only equal to
+	// values of the same type
+	if !boxed &&
+		nodeX.Type.Kind() != types.Interface &&
+		nodeY.Type.Kind() != types.Interface &&
+		!nodeX.Type.Equal(nodeY.Type) {
+		return nil, errors.Errorf("mismatched types %v and %v", nodeX.Type, nodeY.Type)
+	}
+
+	eq, err := valuesEqual(nodeX, nodeY)
+	if err != nil {
+		return nil, err
+	}
+	return NewBoolNode(eq == (op == token.EQL)), nil
+}
+
+// nilEqualityOp compares a value with nil. Values that cannot be compared
+// with each other, like arrays, can still be compared with nil. Other values
+// are never nil, since they can only be compared with nil when they are stored
+// in an interface.
+func nilEqualityOp(op token.Token, nodeX, nodeY *Node) (*Node, error) {
+	if isUntypedNil(nodeX) && isUntypedNil(nodeY) {
+		return nil, errors.Errorf(
+			"invalid operation: nil %v nil (operator %v not defined on nil)",
+			op,
+			op,
+		)
+	}
+	node, x, y := nodeX, fmt.Sprint(nodeX.Value), "nil"
+	if isUntypedNil(nodeX) {
+		node, x, y = nodeY, "nil", fmt.Sprint(nodeY.Value)
+	}
+	if node.untyped {
+		return nil, errors.Errorf(
+			"invalid operation: %s %v %s (mismatched types untyped constant and untyped nil)",
+			x,
+			op,
+			y,
+		)
+	}
+	return NewBoolNode(isNil(node) == (op == token.EQL)), nil
+}
+
+// valuesEqual reports whether two values are equal. Values of different types
+// are never equal, which only happens for values stored in interfaces.
+func valuesEqual(nodeX, nodeY *Node) (bool, error) {
+	if !nodeX.Type.Equal(nodeY.Type) {
+		return false, nil
+	}
+	if !types.Comparable(nodeX.Type) {
+		// interfaces holding values that are not comparable
+		return false, errors.Errorf("comparing uncomparable type %v", nodeX.Type)
+	}
+
+	if nodeX.Type.Kind() == types.Struct {
+		fieldsY := nodeY.Value.([]*Node)
+		for i, field := range nodeX.Value.([]*Node) {
+			eq, err := valuesEqual(field, fieldsY[i])
+			if !eq || err != nil {
+				return false, err
 			}
-			return NewBoolNode(bincomp(expr.Op, operand1, operand2)), nil
-		} else if nodeX.Type.Kind() == types.Int && nodeY.Type.Kind() == types.Int {
-			operand1 := nodeX.Value.(int64)
-			operand2 := nodeY.Value.(int64)
-			return NewBoolNode(bincomp(expr.Op, operand1, operand2)), nil
-		} else {
-			return nil, errors.Errorf("unsupported types %v %v", nodeX.Type, nodeY.Type)
 		}
+		return true, nil
+	}
+
+	// pointers and channels are equal when they refer to the same thing,
+	// which is exactly when their values are equal
+	return nodeX.Value == nodeY.Value, nil
+}
+
+func stringOp(op token.Token, nodeX, nodeY *Node) (*Node, error) {
+	operand1 := nodeX.Value.(string)
+	operand2 := nodeY.Value.(string)
+
+	switch op {
+	case token.ADD:
+		return &Node{
+			Type:  nodeX.Type,
+			Value: operand1 + operand2,
+		}, nil
+	case token.GTR, token.GEQ, token.LSS, token.LEQ, token.EQL, token.NEQ:
+		return NewBoolNode(bincomp(op, operand1, operand2)), nil
+	default:
+		return nil, errors.Errorf("operator %v not defined on string", op)
+	}
+}
+
+func shiftOp(op token.Token, nodeX, nodeY *Node) (*Node, error) {
+	var count uint64
+	switch {
+	case nodeY.untyped && nodeY.Type.Kind() == types.Int:
+		val := constInt(nodeY)
+		if val.Sign() < 0 || !val.IsUint64() {
+			return nil, errors.Errorf("invalid shift count %v", val)
+		}
+		count = val.Uint64()
+	case nodeY.Type.Kind() == types.Int:
+		if nodeY.Value.(int64) < 0 {
+			return nil, runtimePanic("runtime error: negative shift amount")
+		}
+		count = uint64(nodeY.Value.(int64))
+	case nodeY.Type.Kind() == types.Uint:
+		count = nodeY.Value.(uint64)
+	default:
+		return nil, errors.Errorf("shift count type %v must be integer", nodeY.Type)
+	}
+
+	switch {
+	case nodeX.untyped && nodeX.Type.Kind() == types.Int:
+		// shifting a constant gives a constant
+		return constShift(op, nodeX, count)
+	case nodeX.constant && isConst(nodeY):
+		res, err := constShift(op, nodeX, count)
+		if err != nil {
+			return nil, err
+		}
+		return typedConst(res, nodeX.Type)
+	case nodeX.Type.Kind() == types.Int:
+		return newIntegerNode(nodeX.Type, shift(op, nodeX.Value.(int64), count)), nil
+	case nodeX.Type.Kind() == types.Uint:
+		return newIntegerNode(nodeX.Type, shift(op, nodeX.Value.(uint64), count)), nil
 	default:
-		return nil, errors.New("Operation not supported")
+		return nil, errors.Errorf("operator %v not defined on %v", op, nodeX.Type)
 	}
 }
 
 func (m *Machine) evalReturn(expr *ast.ReturnStmt) (*Node, error) {
 	switch len(expr.Results) {
 	case 0:
-		return &Node{}, nil
+		return &Node{IsReturnValue: true}, nil
 	case 1:
 		node, err := m.Evaluate(expr.Results[0])
-		node.IsReturnValue = true
-		return node, err
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, errNoValue
+		}
+		if err := checkConst(node); err != nil {
+			return nil, err
+		}
+		// copy so that we do not flag a node that is still stored
+		// somewhere else
+		res := *node
+		res.IsReturnValue = true
+		res.constant = false
+		return &res, nil
 	default:
 		node := &Node{
 			IsReturnValue: true,
@@ -814,7 +2700,13 @@ func (m *Machine) evalReturn(expr *ast.ReturnStmt) (*Node, error) {
 			if err != nil {
 				return nil, err
 			}
-			node.Elems[i] = resultNode
+			if resultNode == nil {
+				return nil, errNoValue
+			}
+			if err := checkConst(resultNode); err != nil {
+				return nil, err
+			}
+			node.Elems[i] = dropConst(resultNode)
 		}
 		return node, nil
 	}
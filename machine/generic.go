@@ -0,0 +1,599 @@
+package machine
+
+import (
+	"go/ast"
+	"go/token"
+	"slices"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/types"
+)
+
+// packageTypes are the types scripts can use from packages, like
+// constraints.Integer.
+var packageTypes = func() map[string]map[string]types.Type {
+	tilde := func(ts ...types.Type) []types.Term {
+		terms := make([]types.Term, len(ts))
+		for i, t := range ts {
+			terms[i] = types.Term{Type: t, Tilde: true}
+		}
+		return terms
+	}
+	signed := tilde(types.IntType, types.Int8Type, types.Int16Type,
+		types.Int32Type, types.Int64Type)
+	unsigned := tilde(types.UintType, types.Uint8Type, types.Uint16Type,
+		types.Uint32Type, types.Uint64Type)
+	integer := append(slices.Clone(signed), unsigned...)
+	float := tilde(types.FloatType)
+	ordered := append(append(slices.Clone(integer), float...), tilde(types.StringType)...)
+
+	declare := func(name string, terms []types.Term) types.Type {
+		t := types.NamedOf(name)
+		t.SetUnderlying(types.UnionOf(terms))
+		return t
+	}
+	return map[string]map[string]types.Type{
+		"constraints": {
+			"Signed":   declare("constraints.Signed", signed),
+			"Unsigned": declare("constraints.Unsigned", unsigned),
+			"Integer":  declare("constraints.Integer", integer),
+			"Float":    declare("constraints.Float", float),
+			"Ordered":  declare("constraints.Ordered", ordered),
+		},
+		"cmp": {
+			"Ordered": declare("cmp.Ordered", ordered),
+		},
+	}
+}()
+
+// genericType is a type declared with type parameters, like
+// type Stack[T any] struct { items []T }. Every list of type arguments it is
+// used with makes a new declared type.
+type genericType struct {
+	spec *ast.TypeSpec
+	// the context the type is declared in
+	context *context
+
+	instances []*instance
+	// methods declared on the generic type, which every instance gets
+	methods []*genericMethod
+}
+
+func (g *genericType) String() string {
+	return g.spec.Name.Name
+}
+
+// instance is a generic type instantiated with some type arguments.
+type instance struct {
+	args []types.Type
+	t    types.Type
+}
+
+// genericMethod is a method declared on a generic type.
+type genericMethod struct {
+	name string
+	meth *method
+	// the names the receiver gives to the type parameters, like the T in
+	// func (s *Stack[T]) Push(v T)
+	params []string
+}
+
+// typeParamNames returns the names of the type parameters in a list.
+func typeParamNames(params *ast.FieldList) []string {
+	var names []string
+	for _, field := range params.List {
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
+
+// isGeneric reports whether node is a function with type parameters that has
+// not been instantiated yet.
+func isGeneric(node *Node) bool {
+	lit, ok := node.Value.(*ast.FuncLit)
+	return ok && lit.Type.TypeParams != nil
+}
+
+// evalConstraint evaluates a type constraint like any or ~int | float64. Types
+// that are not interfaces are constraints satisfied only by themselves.
+func (m *Machine) evalConstraint(expr ast.Expr) (types.Type, error) {
+	switch n := expr.(type) {
+	case *ast.BinaryExpr:
+		if n.Op != token.OR {
+			break
+		}
+		x, err := m.evalConstraint(n.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := m.evalConstraint(n.Y)
+		if err != nil {
+			return nil, err
+		}
+		return types.Union(x, y)
+	case *ast.UnaryExpr:
+		if n.Op != token.TILDE {
+			break
+		}
+		t, err := m.evalType(n.X)
+		if err != nil {
+			return nil, err
+		}
+		return types.UnionOf([]types.Term{{Type: t, Tilde: true}}), nil
+	}
+
+	t, err := m.evalType(expr)
+	if err != nil {
+		return nil, err
+	}
+	if t.Kind() == types.Interface {
+		return t, nil
+	}
+	return types.UnionOf([]types.Term{{Type: t}}), nil
+}
+
+// evalTypeArgs evaluates the type arguments of an instantiation like f[int].
+func (m *Machine) evalTypeArgs(exprs []ast.Expr) ([]types.Type, error) {
+	args := make([]types.Type, len(exprs))
+	for i, expr := range exprs {
+		var err error
+		args[i], err = m.evalType(expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// bindTypeParams declares the type parameters in params as the types in args
+// in the context ctx, and checks the types against their constraints.
+func (m *Machine) bindTypeParams(ctx *context, params *ast.FieldList, args []types.Type) error {
+	names := typeParamNames(params)
+	if len(args) != len(names) {
+		return errors.Errorf(
+			"wrong number of type arguments, have %d want %d",
+			len(args),
+			len(names),
+		)
+	}
+	for i, name := range names {
+		ctx.Set(name, NewTypeNode(args[i]))
+	}
+
+	// constraints can refer to the type parameters, like in
+	// [S ~[]E, E any]
+	oldContext := m.Context
+	m.Context = ctx
+	defer func() {
+		m.Context = oldContext
+	}()
+
+	i := 0
+	for _, field := range params.List {
+		constraint, err := m.evalConstraint(field.Type)
+		if err != nil {
+			return err
+		}
+		for _, name := range field.Names {
+			err := types.Satisfies(args[i], constraint)
+			if err != nil {
+				return errors.WrapPrefix(err, "cannot use "+args[i].String()+" as "+name.Name, 10)
+			}
+			i++
+		}
+	}
+	return nil
+}
+
+// instantiate evaluates x[args] where x is a generic function or type.
+func (m *Machine) instantiate(xNode *Node, argExprs []ast.Expr) (*Node, error) {
+	switch x := xNode.Value.(type) {
+	case *genericType:
+		args, err := m.evalTypeArgs(argExprs)
+		if err != nil {
+			return nil, err
+		}
+		t, err := m.instantiateType(x, args)
+		if err != nil {
+			return nil, err
+		}
+		return NewTypeNode(t), nil
+	case *ast.FuncLit:
+		if !isGeneric(xNode) {
+			break
+		}
+		args, err := m.evalTypeArgs(argExprs)
+		if err != nil {
+			return nil, err
+		}
+		return m.instantiateFunc(xNode, args)
+	}
+
+	return nil, errors.Errorf("cannot index type %v", xNode.Type)
+}
+
+// instantiateFunc returns a generic function with its type parameters set to
+// args.
+func (m *Machine) instantiateFunc(fun *Node, args []types.Type) (*Node, error) {
+	lit := fun.Value.(*ast.FuncLit)
+	ctx := fun.Context.NewChildContext("instance")
+	err := m.bindTypeParams(ctx, lit.Type.TypeParams, args)
+	if err != nil {
+		return nil, err
+	}
+
+	funcType := *lit.Type
+	funcType.TypeParams = nil
+	return &Node{
+		Type: fun.Type,
+		Value: &ast.FuncLit{
+			Type: &funcType,
+			Body: lit.Body,
+		},
+		Context: ctx,
+	}, nil
+}
+
+// instantiateCall instantiates a generic function for a call. Type arguments
+// that are not given explicitly are inferred from the arguments.
+func (m *Machine) instantiateCall(
+	fun *Node,
+	explicit []types.Type,
+	args []*Node,
+	spread bool,
+) (*Node, error) {
+	lit := fun.Value.(*ast.FuncLit)
+	names := typeParamNames(lit.Type.TypeParams)
+	if len(explicit) > len(names) {
+		return nil, errors.Errorf(
+			"too many type arguments, have %d want %d",
+			len(explicit),
+			len(names),
+		)
+	}
+
+	inf := &inference{
+		bound:    map[string]types.Type{},
+		defaults: map[string]types.Type{},
+	}
+	for i, t := range explicit {
+		inf.bound[names[i]] = t
+	}
+	for _, name := range names[len(explicit):] {
+		inf.bound[name] = nil
+	}
+
+	// the types of the parameters are in terms of where the function is
+	// declared
+	oldContext := m.Context
+	m.Context = fun.Context
+	defer func() {
+		m.Context = oldContext
+	}()
+
+	params := lit.Type.Params.List
+	for i, param := range params {
+		if param.Type == nil {
+			continue
+		}
+		if ellipsis, ok := param.Type.(*ast.Ellipsis); ok && !spread {
+			for _, arg := range args[min(i, len(args)):] {
+				err := m.unifyArg(inf, ellipsis.Elt, arg)
+				if err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+		if i >= len(args) {
+			break
+		}
+		err := m.unifyArg(inf, param.Type, args[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	typeArgs := make([]types.Type, len(names))
+	for i, name := range names {
+		typeArgs[i] = inf.bound[name]
+		if typeArgs[i] == nil {
+			// constants are only used if nothing else was found
+			typeArgs[i] = inf.defaults[name]
+		}
+		if typeArgs[i] == nil {
+			return nil, errors.Errorf("cannot infer %s", name)
+		}
+	}
+	return m.instantiateFunc(fun, typeArgs)
+}
+
+// inference keeps track of the type arguments inferred for a call.
+type inference struct {
+	// the inferred type arguments by name, nil for the ones that are not
+	// inferred yet. Names that are not type parameters are missing.
+	bound map[string]types.Type
+	// the default types of untyped constants passed to type parameters
+	defaults map[string]types.Type
+}
+
+// unifyArg infers type arguments from an argument passed to a parameter of
+// type expr. Function types only have signatures in the literals that make
+// them, so those are matched against the declared types of the literal.
+func (m *Machine) unifyArg(inf *inference, expr ast.Expr, arg *Node) error {
+	ft, ok := expr.(*ast.FuncType)
+	if !ok {
+		return m.unify(inf, expr, arg.Type, arg.untyped)
+	}
+	lit, ok := arg.Value.(*ast.FuncLit)
+	if !ok || lit.Type.TypeParams != nil {
+		return nil
+	}
+	if n := len(lit.Type.Params.List); n > 0 && isVariadic(lit.Type.Params.List[n-1]) {
+		// variadic functions can be called with any number of
+		// arguments, so nothing is inferred from them
+		return nil
+	}
+
+	oldContext := m.Context
+	m.Context = arg.Context
+	params, err := m.fieldTypes(lit.Type.Params)
+	if err != nil {
+		m.Context = oldContext
+		return err
+	}
+	results, err := m.fieldTypes(lit.Type.Results)
+	m.Context = oldContext
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range []struct {
+		exprs []ast.Expr
+		types []types.Type
+	}{
+		{fieldTypeExprs(ft.Params), params},
+		{fieldTypeExprs(ft.Results), results},
+	} {
+		for i, t := range pair.types {
+			if i >= len(pair.exprs) || t == nil {
+				// untyped parameters accept anything
+				continue
+			}
+			err := m.unify(inf, pair.exprs[i], t, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fieldTypeExprs returns the type of each parameter or result in a field
+// list, like [T, T] for (a, b T).
+func fieldTypeExprs(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var res []ast.Expr
+	for _, field := range fields.List {
+		for i := 0; i < max(len(field.Names), 1); i++ {
+			res = append(res, field.Type)
+		}
+	}
+	return res
+}
+
+// unify infers type arguments by matching the type of a parameter against
+// the type of the argument passed to it.
+func (m *Machine) unify(inf *inference, expr ast.Expr, t types.Type, untyped bool) error {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		bound, ok := inf.bound[e.Name]
+		switch {
+		case !ok:
+		case untyped:
+			if inf.defaults[e.Name] == nil {
+				inf.defaults[e.Name] = t
+			}
+		case bound == nil:
+			inf.bound[e.Name] = t
+		case !bound.Equal(t):
+			return errors.Errorf(
+				"type %v does not match inferred type %v for %s",
+				t,
+				bound,
+				e.Name,
+			)
+		}
+	case *ast.ArrayType:
+		if t.Kind() == types.Array {
+			elem, _ := t.Elem()
+			return m.unify(inf, e.Elt, elem, false)
+		}
+	case *ast.Ellipsis:
+		// f(s...) passes the array itself
+		if t.Kind() == types.Array {
+			elem, _ := t.Elem()
+			return m.unify(inf, e.Elt, elem, false)
+		}
+	case *ast.StarExpr:
+		if t.Kind() == types.Pointer {
+			elem, _ := t.Elem()
+			return m.unify(inf, e.X, elem, false)
+		}
+	case *ast.ChanType:
+		if t.Kind() == types.Chan {
+			elem, _ := t.Elem()
+			return m.unify(inf, e.Value, elem, false)
+		}
+	case *ast.MapType:
+		if t.Kind() == types.Map {
+			key, _ := t.Key()
+			elem, _ := t.Elem()
+			err := m.unify(inf, e.Key, key, false)
+			if err != nil {
+				return err
+			}
+			return m.unify(inf, e.Value, elem, false)
+		}
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		// instances of generic types, like Stack[T]
+		x, indices := splitIndex(e)
+		g, err := m.evalGenericType(x)
+		if err != nil {
+			return nil
+		}
+		for _, inst := range g.instances {
+			if inst.t != t || len(inst.args) != len(indices) {
+				continue
+			}
+			for i, index := range indices {
+				err := m.unify(inf, index, inst.args[i], false)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitIndex splits x[a, b] into x and its indices.
+func splitIndex(expr ast.Expr) (ast.Expr, []ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.IndexExpr:
+		return e.X, []ast.Expr{e.Index}
+	case *ast.IndexListExpr:
+		return e.X, e.Indices
+	default:
+		return nil, nil
+	}
+}
+
+// evalGenericType finds the generic type that expr names.
+func (m *Machine) evalGenericType(expr ast.Expr) (*genericType, error) {
+	ident, ok := expr.(*ast.Ident)
+	if !ok {
+		return nil, errors.Errorf("%v is not a generic type", expr)
+	}
+	node := m.Context.Get(ident.Name)
+	if node == nil {
+		return nil, errors.Errorf("unknown type identifier %s", ident.Name)
+	}
+	g, ok := node.Value.(*genericType)
+	if !ok {
+		return nil, errors.Errorf("%s is not a generic type", ident.Name)
+	}
+	return g, nil
+}
+
+// instantiateType returns the declared type for a generic type with the type
+// arguments args. The same arguments always give the same type.
+func (m *Machine) instantiateType(g *genericType, args []types.Type) (types.Type, error) {
+	for _, inst := range g.instances {
+		if len(inst.args) != len(args) {
+			continue
+		}
+		same := true
+		for i, arg := range args {
+			same = same && arg.Equal(inst.args[i])
+		}
+		if same {
+			return inst.t, nil
+		}
+	}
+
+	ctx := g.context.NewChildContext("instance")
+	err := m.bindTypeParams(ctx, g.spec.TypeParams, args)
+	if err != nil {
+		return nil, err
+	}
+
+	argStrs := make([]string, len(args))
+	for i, arg := range args {
+		argStrs[i] = arg.String()
+	}
+	t := types.NamedOf(g.spec.Name.Name + "[" + strings.Join(argStrs, ",") + "]")
+	// the instance is usable before it is finished in case the type
+	// refers to itself
+	inst := &instance{args: args, t: t}
+	g.instances = append(g.instances, inst)
+
+	oldContext := m.Context
+	m.Context = ctx
+	underlying, err := m.evalType(g.spec.Type)
+	m.Context = oldContext
+	if err != nil {
+		g.instances = g.instances[:len(g.instances)-1]
+		return nil, err
+	}
+	t.SetUnderlying(underlying)
+
+	for _, gm := range g.methods {
+		err := m.instantiateMethod(inst, gm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// declareGenericMethod declares a method on a generic type, which is added to
+// all of its instances.
+func (m *Machine) declareGenericMethod(recvType ast.Expr, name string, meth *method) error {
+	x, indices := splitIndex(recvType)
+	g, err := m.evalGenericType(x)
+	if err != nil {
+		return err
+	}
+	if len(indices) != len(typeParamNames(g.spec.TypeParams)) {
+		return errors.Errorf("wrong number of type parameters in receiver of %s", name)
+	}
+
+	gm := &genericMethod{
+		name:   name,
+		meth:   meth,
+		params: make([]string, len(indices)),
+	}
+	for i, index := range indices {
+		ident, ok := index.(*ast.Ident)
+		if !ok {
+			return errors.Errorf("receiver type parameter %v must be an identifier", index)
+		}
+		gm.params[i] = ident.Name
+	}
+
+	g.methods = append(g.methods, gm)
+	for _, inst := range g.instances {
+		err := m.instantiateMethod(inst, gm)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instantiateMethod adds a method of a generic type to one of its instances.
+func (m *Machine) instantiateMethod(inst *instance, gm *genericMethod) error {
+	ctx := gm.meth.fun.Context.NewChildContext("instance")
+	for i, name := range gm.params {
+		if name != "_" {
+			ctx.Set(name, NewTypeNode(inst.args[i]))
+		}
+	}
+
+	return m.declareMethod(inst.t, gm.name, &method{
+		fun: &Node{
+			Type:    gm.meth.fun.Type,
+			Value:   gm.meth.fun.Value,
+			Context: ctx,
+		},
+		recv:    gm.meth.recv,
+		ptrRecv: gm.meth.ptrRecv,
+	})
+}
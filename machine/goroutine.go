@@ -0,0 +1,376 @@
+package machine
+
+import (
+	"go/ast"
+
+	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/types"
+)
+
+// ErrDeadlock is returned when every goroutine of a script is blocked.
+var ErrDeadlock = errors.New("all goroutines are asleep - deadlock!")
+
+// errAbort is used to unwind goroutines that are still alive when the script
+// has finished.
+var errAbort = errors.New("goroutine aborted")
+
+// goroutine is a goroutine started by a script.
+//
+// Every goroutine is backed by a host goroutine, but only one of them is
+// allowed to run at any time. A goroutine runs until it blocks on a channel or
+// finishes, after which the next goroutine in line is resumed. This makes the
+// order of execution deterministic.
+type goroutine struct {
+	// receives nil when the goroutine should continue running, or an error
+	// if it should give up
+	wake chan error
+	// closed when the backing host goroutine exits
+	done chan struct{}
+
+	start   func() (*Node, error)
+	started bool
+
+	// machine state saved while the goroutine is not running
+	ctx   *context
+	frame *frame
+	depth int
+}
+
+type scheduler struct {
+	main    *goroutine
+	current *goroutine
+	// goroutines that are ready to run, in order
+	runq []*goroutine
+	// goroutines whose host goroutine has been started and not exited
+	live []*goroutine
+
+	stopping bool
+}
+
+// goroutines returns the scheduler of the machine, creating one if needed. The
+// goroutine that is running when the scheduler is created is the main one.
+func (m *Machine) goroutines() *scheduler {
+	if m.sched == nil {
+		main := &goroutine{
+			wake:    make(chan error),
+			started: true,
+		}
+		m.sched = &scheduler{
+			main:    main,
+			current: main,
+		}
+	}
+	return m.sched
+}
+
+// spawn adds a new goroutine that will run start once it gets its turn.
+func (m *Machine) spawn(start func() (*Node, error)) {
+	s := m.goroutines()
+	s.runq = append(s.runq, &goroutine{
+		wake:  make(chan error),
+		done:  make(chan struct{}),
+		start: start,
+		ctx:   m.Context,
+		depth: m.maxDepth,
+	})
+}
+
+// ready marks a blocked goroutine as ready to run.
+func (s *scheduler) ready(g *goroutine) {
+	s.runq = append(s.runq, g)
+}
+
+func (s *scheduler) next() *goroutine {
+	if len(s.runq) == 0 {
+		return nil
+	}
+	g := s.runq[0]
+	s.runq = s.runq[1:]
+	return g
+}
+
+// switchTo saves the state of the current goroutine and hands control over to
+// g. The caller must not touch the machine afterwards until it is woken up
+// again.
+func (m *Machine) switchTo(g *goroutine, err error) {
+	s := m.sched
+	s.current.ctx = m.Context
+	s.current.frame = m.frame
+	s.current.depth = m.maxDepth
+	s.current = g
+
+	if !g.started {
+		g.started = true
+		s.live = append(s.live, g)
+		go m.runGoroutine(g)
+		return
+	}
+	g.wake <- err
+}
+
+// park blocks the current goroutine until another goroutine readies it.
+func (m *Machine) park() error {
+	s := m.goroutines()
+	g := s.current
+
+	next := s.next()
+	if next == nil {
+		// nobody else can run, so nobody can wake us up
+		if g == s.main {
+			return ErrDeadlock
+		}
+		m.switchTo(s.main, ErrDeadlock)
+	} else {
+		m.switchTo(next, nil)
+	}
+
+	err := <-g.wake
+	m.Context = g.ctx
+	m.frame = g.frame
+	m.maxDepth = g.depth
+	return err
+}
+
+func (m *Machine) runGoroutine(g *goroutine) {
+	defer close(g.done)
+	m.Context = g.ctx
+	m.frame = nil
+	m.maxDepth = g.depth
+
+	_, err := g.start()
+
+	s := m.sched
+	s.live = deleteGoroutine(s.live, g)
+	if s.stopping {
+		return
+	}
+	if err != nil {
+		// an error in any goroutine stops the whole script
+		var p *PanicError
+		if errors.As(err, &p) {
+			// other goroutines cannot recover from the panic, so it
+			// stops being one
+			err = errors.New(p.Error())
+		}
+		s.runq = deleteGoroutine(s.runq, s.main)
+		m.switchTo(s.main, errors.WrapPrefix(err, "error in goroutine", 10))
+		return
+	}
+
+	next := s.next()
+	if next == nil {
+		// the main goroutine must be blocked, and there is nobody left
+		// to unblock it
+		m.switchTo(s.main, ErrDeadlock)
+		return
+	}
+	m.switchTo(next, nil)
+}
+
+// stopGoroutines kills every goroutine that is still alive, and must be called
+// from the main goroutine once the script has finished.
+func (m *Machine) stopGoroutines() {
+	s := m.sched
+	if s == nil {
+		return
+	}
+
+	ctx := m.Context
+	f := m.frame
+	depth := m.maxDepth
+	s.stopping = true
+	for len(s.live) > 0 {
+		g := s.live[0]
+		g.wake <- errAbort
+		<-g.done
+	}
+
+	m.Context = ctx
+	m.frame = f
+	m.maxDepth = depth
+	m.sched = nil
+}
+
+func deleteGoroutine(gs []*goroutine, g *goroutine) []*goroutine {
+	for i := range gs {
+		if gs[i] == g {
+			return append(gs[:i], gs[i+1:]...)
+		}
+	}
+	return gs
+}
+
+// channel is the value of a channel Node.
+type channel struct {
+	elemType types.Type
+	capacity int
+	buf      []*Node
+	closed   bool
+
+	recvq []*waiter
+	sendq []*waiter
+}
+
+// waiter is a goroutine blocked on a channel.
+type waiter struct {
+	g     *goroutine
+	value *Node
+	ok    bool
+	err   error
+}
+
+func newChannelNode(t types.Type, capacity int) *Node {
+	elemType, _ := t.Elem()
+	return &Node{
+		Type: t,
+		Value: &channel{
+			elemType: elemType,
+			capacity: capacity,
+		},
+	}
+}
+
+func toChannel(node *Node) (*channel, error) {
+	if node.Type.Kind() != types.Chan {
+		return nil, errors.Errorf("%v is not a channel", node.Type)
+	}
+	ch, _ := node.Value.(*channel)
+	return ch, nil
+}
+
+func (m *Machine) send(chNode *Node, value *Node) error {
+	ch, err := toChannel(chNode)
+	if err != nil {
+		return err
+	}
+	if ch == nil {
+		// sending to a nil channel blocks forever
+		return m.park()
+	}
+	if ch.closed {
+		return runtimePanic("send on closed channel")
+	}
+	value, err = promoteTo(value, ch.elemType)
+	if err != nil {
+		return err
+	}
+
+	if len(ch.recvq) > 0 {
+		w := ch.recvq[0]
+		ch.recvq = ch.recvq[1:]
+		w.value = value
+		w.ok = true
+		m.sched.ready(w.g)
+		return nil
+	}
+
+	if len(ch.buf) < ch.capacity {
+		ch.buf = append(ch.buf, value)
+		return nil
+	}
+
+	w := &waiter{g: m.goroutines().current, value: value}
+	ch.sendq = append(ch.sendq, w)
+	err = m.park()
+	if err != nil {
+		return err
+	}
+	return w.err
+}
+
+// recv receives a value from a channel. The returned bool is false if the
+// channel was closed.
+func (m *Machine) recv(chNode *Node) (*Node, bool, error) {
+	ch, err := toChannel(chNode)
+	if err != nil {
+		return nil, false, err
+	}
+	if ch == nil {
+		// receiving from a nil channel blocks forever
+		return nil, false, m.park()
+	}
+
+	if len(ch.buf) > 0 {
+		value := ch.buf[0]
+		ch.buf = ch.buf[1:]
+		// make room for a blocked sender
+		if len(ch.sendq) > 0 {
+			w := ch.sendq[0]
+			ch.sendq = ch.sendq[1:]
+			ch.buf = append(ch.buf, w.value)
+			m.sched.ready(w.g)
+		}
+		return value, true, nil
+	}
+
+	if len(ch.sendq) > 0 {
+		w := ch.sendq[0]
+		ch.sendq = ch.sendq[1:]
+		m.sched.ready(w.g)
+		return w.value, true, nil
+	}
+
+	if ch.closed {
+		return zeroValue(ch.elemType), false, nil
+	}
+
+	w := &waiter{g: m.goroutines().current}
+	ch.recvq = append(ch.recvq, w)
+	err = m.park()
+	if err != nil {
+		return nil, false, err
+	}
+	if !w.ok {
+		return zeroValue(ch.elemType), false, nil
+	}
+	return w.value, true, nil
+}
+
+func (m *Machine) closeChannel(chNode *Node) error {
+	ch, err := toChannel(chNode)
+	if err != nil {
+		return err
+	}
+	if ch == nil {
+		return runtimePanic("close of nil channel")
+	}
+	if ch.closed {
+		return runtimePanic("close of closed channel")
+	}
+
+	ch.closed = true
+	for _, w := range ch.recvq {
+		m.sched.ready(w.g)
+	}
+	for _, w := range ch.sendq {
+		w.err = runtimePanic("send on closed channel")
+		m.sched.ready(w.g)
+	}
+	ch.recvq = nil
+	ch.sendq = nil
+	return nil
+}
+
+func (m *Machine) evalGo(stmt *ast.GoStmt) (*Node, error) {
+	// the function and arguments are evaluated in the calling goroutine
+	call, err := m.evalDeferredCall(stmt.Call)
+	if err != nil {
+		return nil, err
+	}
+	m.spawn(func() (*Node, error) {
+		return m.runDeferredCall(call)
+	})
+	return nil, nil
+}
+
+func (m *Machine) evalSend(stmt *ast.SendStmt) (*Node, error) {
+	chNode, err := m.Evaluate(stmt.Chan)
+	if err != nil {
+		return nil, err
+	}
+	value, err := m.Evaluate(stmt.Value)
+	if err != nil {
+		return nil, err
+	}
+	return nil, m.send(chNode, value)
+}
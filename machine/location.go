@@ -0,0 +1,293 @@
+package machine
+
+import (
+	"go/ast"
+	"reflect"
+	"slices"
+
+	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/types"
+)
+
+// location is somewhere a value can be stored, like a variable or an array
+// element. Pointers hold a location as their value.
+//
+// All implementations are comparable so that pointers can be compared with
+// ==.
+type location interface {
+	load() (*Node, error)
+	store(*Node) error
+}
+
+// varLocation is a variable stored in a context.
+type varLocation struct {
+	ctx  *context
+	name string
+}
+
+func (l varLocation) load() (*Node, error) {
+	return l.ctx.storage[l.name], nil
+}
+
+func (l varLocation) store(n *Node) error {
+	if l.ctx.constants[l.name] {
+		return errors.Errorf("cannot assign to %s (constant)", l.name)
+	}
+	l.ctx.storage[l.name] = dropConst(n)
+	return nil
+}
+
+// elemLocation is an element of an array.
+type elemLocation struct {
+	slot **Node
+}
+
+func (l elemLocation) load() (*Node, error) {
+	return *l.slot, nil
+}
+
+func (l elemLocation) store(n *Node) error {
+	*l.slot = n
+	return nil
+}
+
+// mapEntryLocation is an entry of a map. Map entries cannot be addressed, so
+// these are only used on the left side of assignments.
+type mapEntryLocation struct {
+	mapNode *Node
+	keyNode *Node
+	key     any
+}
+
+func (l mapEntryLocation) load() (*Node, error) {
+	entry, ok := l.mapNode.Value.(map[any]*mapEntry)[l.key]
+	if !ok {
+		elemType, _ := l.mapNode.Type.Elem()
+		return zeroValue(elemType), nil
+	}
+	return entry.Value, nil
+}
+
+func (l mapEntryLocation) store(n *Node) error {
+	entries := l.mapNode.Value.(map[any]*mapEntry)
+	if entries == nil {
+		return runtimePanic("assignment to entry in nil map")
+	}
+	entries[l.key] = &mapEntry{Key: l.keyNode, Value: n}
+	return nil
+}
+
+// blankLocation is the blank identifier _, which throws away what is stored in
+// it.
+type blankLocation struct{}
+
+func (blankLocation) load() (*Node, error) {
+	return nil, errors.New("cannot use _ as value")
+}
+
+func (blankLocation) store(*Node) error {
+	return nil
+}
+
+// fieldLocation is a field of a struct stored in another location. Structs are
+// never modified in place, so storing a field stores a copy of the whole
+// struct into the parent.
+type fieldLocation struct {
+	parent location
+	index  int
+}
+
+func (l fieldLocation) load() (*Node, error) {
+	parent, err := l.parent.load()
+	if err != nil {
+		return nil, err
+	}
+	return parent.Value.([]*Node)[l.index], nil
+}
+
+func (l fieldLocation) store(n *Node) error {
+	parent, err := l.parent.load()
+	if err != nil {
+		return err
+	}
+	fields, _ := parent.Type.Fields()
+	n, err = promoteTo(n, fields[l.index].Type)
+	if err != nil {
+		return err
+	}
+
+	values := slices.Clone(parent.Value.([]*Node))
+	values[l.index] = n
+	return l.parent.store(&Node{
+		Type:  parent.Type,
+		Value: values,
+	})
+}
+
+// heapLocation is an anonymous location, like the ones created by new(T) or
+// &T{}.
+type heapLocation struct {
+	node *Node
+}
+
+func (l *heapLocation) load() (*Node, error) {
+	return l.node, nil
+}
+
+func (l *heapLocation) store(n *Node) error {
+	l.node = n
+	return nil
+}
+
+func newPointerNode(elemType types.Type, loc location) *Node {
+	return &Node{
+		Type:  types.PointerOf(elemType),
+		Value: loc,
+	}
+}
+
+// deref returns the location a pointer node points to.
+func deref(ptr *Node) (location, error) {
+	if ptr.Type.Kind() != types.Pointer {
+		return nil, errors.Errorf("invalid indirect of type %v", ptr.Type)
+	}
+	loc, _ := ptr.Value.(location)
+	if loc == nil {
+		return nil, runtimePanic("runtime error: invalid memory address or nil pointer dereference")
+	}
+	return loc, nil
+}
+
+// evalLocation finds the location an addressable expression refers to.
+func (m *Machine) evalLocation(expr ast.Expr) (location, error) {
+	switch n := expr.(type) {
+	case *ast.Ident:
+		ctx := m.Context.lookup(n.Name)
+		if ctx == nil {
+			return nil, errors.Errorf("cannot find identifier %s", n.Name)
+		}
+		return varLocation{ctx: ctx, name: n.Name}, nil
+	case *ast.IndexExpr:
+		xNode, err := m.Evaluate(n.X)
+		if err != nil {
+			return nil, err
+		}
+		if xNode.Type.Kind() != types.Array {
+			return nil, errors.Errorf("cannot take address of element of %v", xNode.Type)
+		}
+		return m.evalElemLocation(xNode, n.Index)
+	case *ast.SelectorExpr:
+		xNode, err := m.Evaluate(n.X)
+		if err != nil {
+			return nil, err
+		}
+
+		var parent location
+		if xNode.Type.Kind() == types.Pointer {
+			// p.X is shorthand for (*p).X
+			parent, err = deref(xNode)
+			if err != nil {
+				return nil, err
+			}
+			xNode, err = parent.load()
+		} else {
+			parent, err = m.evalLocation(n.X)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		index, err := fieldIndex(xNode.Type, n.Sel.Name)
+		if err != nil {
+			return nil, err
+		}
+		return fieldLocation{parent: parent, index: index}, nil
+	case *ast.StarExpr:
+		ptr, err := m.Evaluate(n.X)
+		if err != nil {
+			return nil, err
+		}
+		return deref(ptr)
+	case *ast.ParenExpr:
+		return m.evalLocation(n.X)
+	default:
+		return nil, errors.Errorf("cannot take address of %v", reflect.TypeOf(expr))
+	}
+}
+
+// evalElemLocation finds the location of the element at an index of an array.
+func (m *Machine) evalElemLocation(arrNode *Node, indexExpr ast.Expr) (location, error) {
+	indexNode, err := m.Evaluate(indexExpr)
+	if err != nil {
+		return nil, err
+	}
+	index, err := indexNode.ToInt()
+	if err != nil {
+		return nil, err
+	}
+	arr := arrNode.Value.([]*Node)
+	if index < 0 || index >= int64(len(arr)) {
+		return nil, runtimePanic(
+			"runtime error: index out of range [%d] with length %d",
+			index,
+			len(arr),
+		)
+	}
+	return elemLocation{slot: &arr[index]}, nil
+}
+
+// evalMapEntryLocation finds the location of the entry for a key in a map.
+func (m *Machine) evalMapEntryLocation(mapNode *Node, keyExpr ast.Expr) (location, error) {
+	keyType, _ := mapNode.Type.Key()
+	keyNode, err := m.Evaluate(keyExpr)
+	if err != nil {
+		return nil, err
+	}
+	keyNode, err = promoteTo(keyNode, keyType)
+	if err != nil {
+		return nil, err
+	}
+	key, err := mapKey(keyNode)
+	if err != nil {
+		return nil, err
+	}
+	return mapEntryLocation{mapNode: mapNode, keyNode: keyNode, key: key}, nil
+}
+
+// evalAddress evaluates &expr.
+func (m *Machine) evalAddress(expr ast.Expr) (*Node, error) {
+	if lit, ok := expr.(*ast.CompositeLit); ok {
+		// &T{} allocates a new T
+		node, err := m.evalComposite(lit)
+		if err != nil {
+			return nil, err
+		}
+		return newPointerNode(node.Type, &heapLocation{node: node}), nil
+	}
+
+	loc, err := m.evalLocation(expr)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := loc.(varLocation); ok && v.ctx.constants[v.name] {
+		return nil, errors.Errorf("cannot take address of constant %s", v.name)
+	}
+	node, err := loc.load()
+	if err != nil {
+		return nil, err
+	}
+	return newPointerNode(node.Type, loc), nil
+}
+
+// evalStar evaluates *expr.
+func (m *Machine) evalStar(expr *ast.StarExpr) (*Node, error) {
+	ptr, err := m.Evaluate(expr.X)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := deref(ptr)
+	if err != nil {
+		return nil, err
+	}
+	return loc.load()
+}
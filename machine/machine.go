@@ -3,15 +3,24 @@ package machine
 import (
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"math"
 
 	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/types"
 )
 
 type Machine struct {
 	Context *context
 
+	// the function call being evaluated, nil outside of functions
+	frame *frame
+	// runs the goroutines started by scripts, nil if there are none
+	sched *scheduler
+	// methods declared on named types, by type and then by name
+	methods map[types.Type]map[string]*method
+
 	// whether to print out the ast and some other debugging stuff
 	debugFlag bool
 	maxDepth  int
@@ -48,13 +57,42 @@ func (m *Machine) ParseAndEval(stmt string) (*Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	return m.Evaluate(node)
+
+	defer m.stopGoroutines()
+	res, err := m.Evaluate(node)
+	if err != nil || res == nil {
+		return res, err
+	}
+	// constants too big for an int cannot be returned
+	if err := checkConst(res); err != nil {
+		return nil, err
+	}
+	return res, nil
 }
 
+// Parse parses and preprocesses a script. A script is either a single
+// expression, or a list of declarations like a go file without the package
+// clause.
 func (m *Machine) Parse(stmt string) (ast.Node, error) {
+	var parsed ast.Node
 	parsed, err := parser.ParseExpr(stmt)
 	if err != nil {
-		return nil, errors.WrapPrefix(err, "cannot parse", 10)
+		file, fileErr := parser.ParseFile(
+			token.NewFileSet(),
+			"",
+			filePrefix+stmt,
+			// the preprocessor keeps its own data in ast.Objects
+			parser.SkipObjectResolution,
+		)
+		if fileErr != nil {
+			// report whichever error got further into the script,
+			// since that is probably what the script was meant to be
+			if errorOffset(fileErr)-len(filePrefix) > errorOffset(err) {
+				err = fileErr
+			}
+			return nil, errors.WrapPrefix(err, "cannot parse", 10)
+		}
+		parsed = file
 	}
 
 	err = m.Preprocess(parsed)
@@ -69,6 +107,20 @@ func (m *Machine) Parse(stmt string) (ast.Node, error) {
 	return parsed, err
 }
 
+// The package clause that scripts made of declarations leave out. The line
+// directive keeps the positions in parse errors relative to the script.
+const filePrefix = "package script;/*line :1:1*/"
+
+// errorOffset returns the offset in the source of the first error in a list of
+// parse errors.
+func errorOffset(err error) int {
+	var list scanner.ErrorList
+	if errors.As(err, &list) && len(list) != 0 {
+		return list[0].Pos.Offset
+	}
+	return 0
+}
+
 // AddToGlobalContext adds a variable to the global context. Similar to running
 // the expression name := val before any script is executed.
 func (m *Machine) AddToGlobalContext(name string, val any) error {
@@ -79,9 +131,16 @@ func (m *Machine) AddToGlobalContext(name string, val any) error {
 	return m.Context.Set(name, node)
 }
 
+// CallFunction calls a function returned by a script. If the last result of the
+// function is an error, it is returned as a go error instead of a Node.
 func (m *Machine) CallFunction(fun *Node, args []*Node) (*Node, error) {
-	if _, ok := fun.Value.(*ast.FuncLit); ok {
-		return m.applyFunction(fun, args)
+	if lit, ok := fun.Value.(*ast.FuncLit); ok {
+		defer m.stopGoroutines()
+		res, err := m.applyFunction(fun, args, false)
+		if err != nil {
+			return nil, err
+		}
+		return m.splitError(lit.Type, fun.Context, res)
 	} else {
 		return nil, errors.New(
 			"the supplied function should be a result from calling Evaluate.",
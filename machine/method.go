@@ -0,0 +1,135 @@
+package machine
+
+import (
+	"go/ast"
+
+	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/types"
+)
+
+// method is a method declared on a named type.
+type method struct {
+	// the method as a function, with the receiver left out of its
+	// parameters
+	fun *Node
+	// the name the receiver is bound to in the body, if any
+	recv string
+	// whether the receiver is a pointer, like in func (p *Point) Scale()
+	ptrRecv bool
+}
+
+// evalFuncDecl declares a function or a method in the current context.
+func (m *Machine) evalFuncDecl(decl *ast.FuncDecl) error {
+	fun := &Node{
+		Type: types.FuncType,
+		Value: &ast.FuncLit{
+			Type: decl.Type,
+			Body: decl.Body,
+		},
+		Context: m.Context,
+	}
+	if decl.Recv == nil {
+		return m.Context.Set(decl.Name.Name, fun)
+	}
+
+	if len(decl.Recv.List) != 1 {
+		return errors.Errorf("method %s must have exactly one receiver", decl.Name.Name)
+	}
+	field := decl.Recv.List[0]
+	meth := &method{fun: fun}
+	if len(field.Names) != 0 {
+		meth.recv = field.Names[0].Name
+	}
+
+	typeExpr := field.Type
+	if star, ok := typeExpr.(*ast.StarExpr); ok {
+		meth.ptrRecv = true
+		typeExpr = star.X
+	}
+	switch typeExpr.(type) {
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		return m.declareGenericMethod(typeExpr, decl.Name.Name, meth)
+	}
+	t, err := m.evalType(typeExpr)
+	if err != nil {
+		return err
+	}
+	return m.declareMethod(t, decl.Name.Name, meth)
+}
+
+// declareMethod adds a method to the method set of t.
+func (m *Machine) declareMethod(t types.Type, name string, meth *method) error {
+	sig := signatureOf(name, meth.fun.Value.(*ast.FuncLit).Type)
+	sig.PointerRecv = meth.ptrRecv
+	err := t.AddMethod(sig)
+	if err != nil {
+		return err
+	}
+
+	if m.methods == nil {
+		m.methods = make(map[types.Type]map[string]*method)
+	}
+	if m.methods[t] == nil {
+		m.methods[t] = make(map[string]*method)
+	}
+	m.methods[t][name] = meth
+	return nil
+}
+
+// signatureOf describes the method name with the function type ft.
+func signatureOf(name string, ft *ast.FuncType) types.Method {
+	res := types.Method{
+		Name:    name,
+		Params:  ft.Params.NumFields(),
+		Results: ft.Results.NumFields(),
+	}
+	if n := len(ft.Params.List); n > 0 {
+		res.Variadic = isVariadic(ft.Params.List[n-1])
+	}
+	return res
+}
+
+// lookupMethod finds the method called name of a type, or of the type a
+// pointer points to. It returns nil if there is no such method.
+func (m *Machine) lookupMethod(t types.Type, name string) *method {
+	if t.Kind() == types.Pointer && t.Name() == "" {
+		t, _ = t.Elem()
+	}
+	return m.methods[t][name]
+}
+
+// bindMethod returns the method meth bound to the receiver x, which is the
+// result of evaluating xExpr. The receiver is addressed or dereferenced to
+// match what the method expects.
+func (m *Machine) bindMethod(meth *method, xExpr ast.Expr, x *Node) (*Node, error) {
+	isPtr := x.Type.Kind() == types.Pointer && x.Type.Name() == ""
+	var err error
+	switch {
+	case meth.ptrRecv && !isPtr:
+		// v.M() is shorthand for (&v).M()
+		x, err = m.evalAddress(xExpr)
+		if err != nil {
+			return nil, errors.WrapPrefix(err, "cannot call pointer method", 10)
+		}
+	case !meth.ptrRecv && isPtr:
+		// p.M() is shorthand for (*p).M()
+		loc, err := deref(x)
+		if err != nil {
+			return nil, err
+		}
+		x, err = loc.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	context := meth.fun.Context.NewChildContext("method")
+	if meth.recv != "" {
+		context.Set(meth.recv, unbox(x))
+	}
+	return &Node{
+		Type:    meth.fun.Type,
+		Value:   meth.fun.Value,
+		Context: context,
+	}, nil
+}
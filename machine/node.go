@@ -2,14 +2,19 @@ package machine
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/go-errors/errors"
 	"github.com/podocarp/goscript/types"
 )
 
+var anyReflectType = reflect.TypeOf((*any)(nil)).Elem()
+
 type Node struct {
 	Type    types.Type
 	Value   any
@@ -21,21 +26,137 @@ type Node struct {
 	IsReturnValue bool
 	IsContinue    bool
 	IsBreak       bool
+	IsGoto        bool
+	// The label of a break, continue or goto, if any
+	Label string
+
+	// Whether the node is an untyped integer constant, like 1 or 'a'.
+	// Untyped constants take on the type of whatever they are used with.
+	untyped bool
+	// Whether the node is a typed integer constant, like a in
+	// const a int8 = 1. Operations on constants are checked for overflow
+	// instead of wrapping around.
+	constant bool
+	// Whether the node is a value stored in an interface. Its type is the
+	// type of the value, and it is never nil even if the value is, like a
+	// nil pointer stored in an error.
+	boxed bool
+}
+
+// interrupts reports whether evaluating to n stops the rest of the enclosing
+// statements from running.
+func (n *Node) interrupts() bool {
+	return n != nil && (n.IsReturnValue || n.IsContinue || n.IsBreak || n.IsGoto)
+}
+
+// mapEntry is a single key value pair stored in a map Node. Maps are stored as
+// a map[any]*mapEntry where the key of the go map is derived from the key Node
+// by mapKey. The original key Node is kept around for ranging and
+// conversions.
+type mapEntry struct {
+	Key   *Node
+	Value *Node
+}
+
+// boxedKey is the key of a value stored in an interface. Values in interfaces
+// are only equal when their types are, so 1 and int8(1) are different keys of
+// a map[any]int.
+type boxedKey struct {
+	t   string
+	key any
+}
+
+// mapKey converts a Node into a value that can be used as a key in a go map.
+func mapKey(n *Node) (any, error) {
+	if n.boxed {
+		key, err := mapKey(unbox(n))
+		if err != nil {
+			return nil, err
+		}
+		return boxedKey{t: n.Type.String(), key: key}, nil
+	}
+
+	switch n.Type.Kind() {
+	case types.Bool, types.Float, types.Int, types.String, types.Uint:
+		return n.Value, nil
+	case types.Struct:
+		// go arrays of comparable values are themselves comparable, so
+		// they can be used to key structs by all their fields
+		fields := n.Value.([]*Node)
+		key := reflect.New(reflect.ArrayOf(len(fields), anyReflectType)).Elem()
+		for i, field := range fields {
+			fieldKey, err := mapKey(field)
+			if err != nil {
+				return nil, err
+			}
+			key.Index(i).Set(reflect.ValueOf(fieldKey))
+		}
+		return key.Interface(), nil
+	case types.Pointer, types.Chan:
+		// pointers and channels are equal when they refer to the same
+		// thing, which is exactly when their values are equal
+		return n.Value, nil
+	default:
+		return nil, errors.Errorf("invalid map key type %v", n.Type)
+	}
+}
+
+func structToString(fields []*Node) string {
+	strs := make([]string, len(fields))
+	for i, field := range fields {
+		strs[i] = elemToString(field)
+	}
+
+	return "{" + strings.Join(strs, " ") + "}"
+}
+
+func mapToString(entries map[any]*mapEntry) string {
+	strs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		strs = append(strs, fmt.Sprintf(
+			"%s:%s",
+			elemToString(entry.Key),
+			elemToString(entry.Value),
+		))
+	}
+	sort.Strings(strs)
+
+	return "map[" + strings.Join(strs, " ") + "]"
+}
+
+// elemToString formats a Node nested in an array, map or struct.
+func elemToString(elem *Node) string {
+	if elem == nil {
+		return "<NIL>"
+	}
+
+	switch elem.Type.Kind() {
+	case types.Array:
+		return arrToString(elem.Value.([]*Node))
+	case types.Map:
+		return mapToString(elem.Value.(map[any]*mapEntry))
+	case types.Struct:
+		return structToString(elem.Value.([]*Node))
+	case types.Pointer:
+		return ptrToString(elem)
+	default:
+		return fmt.Sprint(elem.Value)
+	}
+}
+
+func ptrToString(ptr *Node) string {
+	// pointers are not followed since they might be cyclic
+	if ptr.Value == nil {
+		return "<nil>"
+	}
+	return "&" + ptr.Type.String()
 }
 
 func arrToString(arr []*Node) string {
 	var arrContents strings.Builder
 	arrContents.WriteString("[ ")
 	for _, elem := range arr {
-		if elem == nil {
-			arrContents.WriteString("<NIL>")
-		}
-
-		if elem.Type.Kind() == types.Array {
-			arrContents.WriteString(arrToString(elem.Value.([]*Node)))
-		} else {
-			arrContents.WriteString(fmt.Sprint(elem.Value))
-		}
+		arrContents.WriteString(elemToString(elem))
 		arrContents.WriteString(" ")
 	}
 	arrContents.WriteString("]")
@@ -52,9 +173,25 @@ func (n *Node) String() string {
 		switch n.Type.Kind() {
 		case types.Array:
 			val = arrToString(n.Value.([]*Node))
+		case types.Map:
+			val = mapToString(n.Value.(map[any]*mapEntry))
+		case types.Struct:
+			val = structToString(n.Value.([]*Node))
+		case types.Pointer:
+			val = ptrToString(n)
+		case types.Chan:
+			val = fmt.Sprintf("%p", n.Value)
+		case types.Interface:
+			// only nil interfaces have this kind, others carry the
+			// type of the value inside
+			val = "<nil>"
+		case types.TypeName:
+			val = fmt.Sprint(n.Value)
+		case types.Bool:
+			val = fmt.Sprint(n.Value)
 		case types.Float:
 			val = fmt.Sprint(n.Value)
-		case types.Int:
+		case types.Int, types.Uint:
 			val = fmt.Sprint(n.Value)
 		case types.String:
 			val = strconv.Quote(fmt.Sprint(n.Value))
@@ -85,6 +222,12 @@ func (n *Node) String() string {
 	if n.IsBreak {
 		val = val + " brk"
 	}
+	if n.IsGoto {
+		val = val + " goto"
+	}
+	if n.Label != "" {
+		val = val + " " + n.Label
+	}
 
 	return fmt.Sprintf(
 		"Node(%s) %s",
@@ -98,6 +241,9 @@ func (n *Node) ToInt() (int64, error) {
 	case types.Float:
 		return int64(n.Value.(float64)), nil
 	case types.Int:
+		if val, ok := n.Value.(*big.Int); ok {
+			return 0, errors.Errorf("constant %v overflows int64", val)
+		}
 		return n.Value.(int64), nil
 	case types.Uint:
 		return int64(n.Value.(uint64)), nil
@@ -106,11 +252,34 @@ func (n *Node) ToInt() (int64, error) {
 	}
 }
 
+func (n *Node) ToUint() (uint64, error) {
+	switch n.Type.Kind() {
+	case types.Float:
+		return uint64(n.Value.(float64)), nil
+	case types.Int:
+		if val, ok := n.Value.(*big.Int); ok {
+			if !val.IsUint64() {
+				return 0, errors.Errorf("constant %v overflows uint64", val)
+			}
+			return val.Uint64(), nil
+		}
+		return uint64(n.Value.(int64)), nil
+	case types.Uint:
+		return n.Value.(uint64), nil
+	default:
+		return 0, errors.Errorf("cannot convert type %v to uint", n.Type)
+	}
+}
+
 func (n *Node) ToFloat() (float64, error) {
 	switch n.Type.Kind() {
 	case types.Float:
 		return n.Value.(float64), nil
 	case types.Int:
+		if val, ok := n.Value.(*big.Int); ok {
+			f, _ := new(big.Float).SetInt(val).Float64()
+			return f, nil
+		}
 		return float64(n.Value.(int64)), nil
 	case types.Uint:
 		return float64(n.Value.(uint64)), nil
@@ -120,31 +289,111 @@ func (n *Node) ToFloat() (float64, error) {
 }
 
 func (n *Node) NodeToValue() reflect.Value {
+	return n.toValue(nil, map[location]reflect.Value{})
+}
+
+// toValue converts n to a reflect.Value of type t, or of the type of n if t is
+// nil. Types that refer to themselves are converted to any inside themselves,
+// so t is not always the type of n.
+//
+// Pointers that are already converted are kept in ptrs, so that pointers to
+// the same thing stay the same. Pointers that are still being converted are
+// invalid values there, and are cut to nil when a cyclic value like a list
+// pointing to itself reaches them again.
+func (n *Node) toValue(t reflect.Type, ptrs map[location]reflect.Value) reflect.Value {
+	if t == nil || t.Kind() == reflect.Interface && n.Type.Kind() != types.Interface {
+		// values stored in interfaces keep their own type
+		own := n.Type.TypeToReflectType()
+		if t == nil || own == nil || own.Kind() == reflect.Interface {
+			t = own
+		} else {
+			res := n.toValue(own, ptrs)
+			if !res.Type().AssignableTo(t) {
+				return reflect.Zero(t)
+			}
+			return res
+		}
+	}
+
 	switch n.Type.Kind() {
 	case types.Array:
 		arr := n.Value.([]*Node)
-		length := len(arr)
-
-		sliceType := n.Type.TypeToReflectType()
-		res := reflect.MakeSlice(sliceType, 0, length)
+		res := reflect.MakeSlice(t, 0, len(arr))
 		for _, elem := range arr {
-			res = reflect.Append(res, elem.NodeToValue())
+			res = reflect.Append(res, elem.toValue(t.Elem(), ptrs))
 		}
 		return res
+	case types.Map:
+		entries := n.Value.(map[any]*mapEntry)
+		if entries == nil {
+			return reflect.Zero(t)
+		}
+
+		res := reflect.MakeMapWithSize(t, len(entries))
+		for _, entry := range entries {
+			res.SetMapIndex(
+				entry.Key.toValue(t.Key(), ptrs),
+				entry.Value.toValue(t.Elem(), ptrs),
+			)
+		}
+		return res
+	case types.Struct:
+		res := reflect.New(t).Elem()
+		for i, field := range n.Value.([]*Node) {
+			// unexported fields cannot be set, so they are left as
+			// zero values
+			if fieldValue := res.Field(i); fieldValue.CanSet() {
+				fieldValue.Set(field.toValue(fieldValue.Type(), ptrs))
+			}
+		}
+		return res
+	case types.Pointer:
+		loc, _ := n.Value.(location)
+		if loc == nil {
+			return reflect.Zero(t)
+		}
+		if res, ok := ptrs[loc]; ok {
+			if !res.IsValid() || res.Type() != t {
+				// a cycle back to a value that is still being
+				// converted, or the same thing seen as another
+				// type
+				return reflect.Zero(t)
+			}
+			return res
+		}
+		elem, err := loc.load()
+		if err != nil {
+			return reflect.Zero(t)
+		}
+		ptrs[loc] = reflect.Value{}
+		res := reflect.New(t.Elem())
+		res.Elem().Set(elem.toValue(t.Elem(), ptrs))
+		ptrs[loc] = res
+		return res
+	case types.Bool:
+		return reflect.ValueOf(n.Value.(bool))
 	case types.Float:
 		return reflect.ValueOf(n.Value.(float64))
 	case types.Func:
 		return reflect.ValueOf(n.Value)
+	case types.Error:
+		return reflect.ValueOf(n.Value)
+	case types.Interface:
+		return reflect.Zero(t)
 	case types.Int:
-		return reflect.ValueOf(n.Value.(int64))
+		if val, ok := n.Value.(*big.Int); ok {
+			// constants too big for any integer type
+			return reflect.ValueOf(val)
+		}
+		return reflect.ValueOf(n.Value.(int64)).Convert(t)
 	case types.String:
 		return reflect.ValueOf(n.Value.(string))
 	case types.Uint:
-		return reflect.ValueOf(n.Value.(uint64))
+		return reflect.ValueOf(n.Value.(uint64)).Convert(t)
 	case types.Packing:
 		values := make([]reflect.Value, len(n.Elems))
 		for i, elem := range n.Elems {
-			values[i] = elem.NodeToValue()
+			values[i] = elem.toValue(nil, ptrs)
 		}
 		return reflect.ValueOf(values)
 	default:
@@ -159,6 +408,14 @@ func ValueToNode(val any) (*Node, error) {
 }
 
 func valueToNodeHelper(val reflect.Value) (*Node, error) {
+	if val.IsValid() && val.Kind() != reflect.Interface && val.CanInterface() &&
+		val.Type().Implements(types.ErrorType.TypeToReflectType()) {
+		return &Node{
+			Type:  types.GoErrorType,
+			Value: val.Interface().(error),
+		}, nil
+	}
+
 	switch val.Kind() {
 	case reflect.Array, reflect.Slice:
 		res := make([]*Node, val.Len())
@@ -179,6 +436,82 @@ func valueToNodeHelper(val reflect.Value) (*Node, error) {
 			Type:  arrType,
 			Value: res,
 		}, nil
+	case reflect.Map:
+		mapType, err := types.ReflectTypeToType(val.Type())
+		if err != nil {
+			return nil, err
+		}
+		if val.IsNil() {
+			return &Node{
+				Type:  mapType,
+				Value: map[any]*mapEntry(nil),
+			}, nil
+		}
+
+		res := make(map[any]*mapEntry, val.Len())
+		iter := val.MapRange()
+		for iter.Next() {
+			keyNode, err := valueToNodeHelper(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			valNode, err := valueToNodeHelper(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			if val.Type().Key().Kind() == reflect.Interface {
+				keyNode = box(keyNode)
+			}
+			key, err := mapKey(keyNode)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = &mapEntry{Key: keyNode, Value: valNode}
+		}
+		return &Node{
+			Type:  mapType,
+			Value: res,
+		}, nil
+	case reflect.Pointer:
+		ptrType, err := types.ReflectTypeToType(val.Type())
+		if err != nil {
+			return nil, err
+		}
+		if val.IsNil() {
+			return &Node{Type: ptrType}, nil
+		}
+		elem, err := valueToNodeHelper(val.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Type:  ptrType,
+			Value: &heapLocation{node: elem},
+		}, nil
+	case reflect.Struct:
+		structType, err := types.ReflectTypeToType(val.Type())
+		if err != nil {
+			return nil, err
+		}
+		res := make([]*Node, val.NumField())
+		for i := range res {
+			res[i], err = valueToNodeHelper(val.Field(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return &Node{
+			Type:  structType,
+			Value: res,
+		}, nil
+	case reflect.Interface:
+		if val.IsNil() {
+			return &Node{Type: types.AnyType}, nil
+		}
+		// the node carries the type of the value inside
+		return valueToNodeHelper(val.Elem())
+	case reflect.Bool:
+		return NewBoolNode(val.Bool()), nil
 	case reflect.Float32, reflect.Float64:
 		return &Node{
 			Type:  types.FloatType,
@@ -186,8 +519,12 @@ func valueToNodeHelper(val reflect.Value) (*Node, error) {
 		}, nil
 	case reflect.Int, reflect.Int8,
 		reflect.Int16, reflect.Int32, reflect.Int64:
+		intType, err := types.ReflectTypeToType(val.Type())
+		if err != nil {
+			return nil, err
+		}
 		return &Node{
-			Type:  types.IntType,
+			Type:  intType,
 			Value: val.Int(),
 		}, nil
 	case reflect.String:
@@ -197,8 +534,12 @@ func valueToNodeHelper(val reflect.Value) (*Node, error) {
 		}, nil
 	case reflect.Uint, reflect.Uint8,
 		reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		uintType, err := types.ReflectTypeToType(val.Type())
+		if err != nil {
+			return nil, err
+		}
 		return &Node{
-			Type:  types.UintType,
+			Type:  uintType,
 			Value: val.Uint(),
 		}, nil
 	default:
@@ -233,3 +574,306 @@ func NewUintNode(val uint64) *Node {
 		Value: val,
 	}
 }
+
+// newIntegerNode returns an integer node of type t. Values that do not fit in t
+// wrap around like they do in go.
+func newIntegerNode[T int64 | uint64](t types.Type, val T) *Node {
+	shift := 64 - t.Bits()
+	return &Node{
+		Type:  t,
+		Value: val << shift >> shift,
+	}
+}
+
+func NewTypeNode(t types.Type) *Node {
+	return &Node{
+		Type:  types.TypeNameType,
+		Value: t,
+	}
+}
+
+func NewPackingNode(elems ...*Node) *Node {
+	return &Node{
+		Type:  types.LiteralOf(types.Packing),
+		Elems: elems,
+	}
+}
+
+// zeroValue returns the zero value of the type t.
+func zeroValue(t types.Type) *Node {
+	switch t.Kind() {
+	case types.Bool:
+		return &Node{Type: t, Value: false}
+	case types.Float:
+		return &Node{Type: t, Value: float64(0)}
+	case types.Int:
+		return &Node{Type: t, Value: int64(0)}
+	case types.Uint:
+		return &Node{Type: t, Value: uint64(0)}
+	case types.String:
+		return &Node{Type: t, Value: ""}
+	case types.Array:
+		return &Node{Type: t, Value: []*Node(nil)}
+	case types.Map:
+		return &Node{Type: t, Value: map[any]*mapEntry(nil)}
+	case types.Struct:
+		fields, _ := t.Fields()
+		res := make([]*Node, len(fields))
+		for i, field := range fields {
+			res[i] = zeroValue(field.Type)
+		}
+		return &Node{Type: t, Value: res}
+	default:
+		return &Node{Type: t}
+	}
+}
+
+// newNilNode returns the value of nil, which can be used as any type that has
+// a nil value.
+func newNilNode() *Node {
+	return &Node{Type: types.AnyType, untyped: true}
+}
+
+// isUntypedNil reports whether node is nil that has not been given a type yet.
+func isUntypedNil(node *Node) bool {
+	return node.untyped && node.Type.Kind() == types.Interface
+}
+
+// isNil reports whether node is the nil value of its type.
+func isNil(node *Node) bool {
+	if node.boxed {
+		return false
+	}
+	switch node.Type.Kind() {
+	case types.Interface:
+		// only nil interfaces have this kind
+		return true
+	case types.Array:
+		return node.Value.([]*Node) == nil
+	case types.Map:
+		return node.Value.(map[any]*mapEntry) == nil
+	case types.Pointer, types.Chan, types.Func:
+		return node.Value == nil || node.Value == (*channel)(nil)
+	default:
+		return false
+	}
+}
+
+// promoteTo checks that node can be used as a value of type t, promoting
+// numeric values to floats if needed. A new node is returned if any conversion
+// was done.
+func promoteTo(node *Node, t types.Type) (*Node, error) {
+	if isUntypedNil(node) {
+		switch t.Kind() {
+		case types.Array, types.Map, types.Pointer, types.Chan, types.Func,
+			types.Interface:
+			if types.IsConstraint(t) {
+				return nil, errors.Errorf("cannot use %v outside a type constraint", t)
+			}
+			return zeroValue(t), nil
+		default:
+			return nil, errors.Errorf("cannot use nil as %v", t)
+		}
+	}
+	if node.untyped {
+		switch t.Kind() {
+		case types.Int, types.Uint:
+			return convertConst(node, t)
+		case types.Interface:
+			var err error
+			node, err = defaultType(node)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if node.Type.Equal(t) {
+		return unbox(dropConst(node)), nil
+	}
+
+	if t.Kind() == types.Interface {
+		if types.IsConstraint(t) {
+			return nil, errors.Errorf("cannot use %v outside a type constraint", t)
+		}
+		if node.Type.Kind() == types.Interface {
+			// nil fits in any interface
+			return &Node{Type: t}, nil
+		}
+		// values stored in an interface keep their own type
+		err := types.Implements(node.Type, t)
+		if err != nil {
+			return nil, err
+		}
+		return box(node), nil
+	}
+
+	if t.Kind() == types.Float && node.Type.Kind().IsNumeric() {
+		val, err := node.ToFloat()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Type: t, Value: val}, nil
+	}
+
+	return nil, errors.Errorf("cannot use %v as %v", node.Type, t)
+}
+
+// box returns node as a value stored in an interface.
+func box(node *Node) *Node {
+	if node.boxed {
+		return node
+	}
+	res := *node
+	res.boxed = true
+	res.constant = false
+	return &res
+}
+
+// unbox returns the value stored in the interface node.
+func unbox(node *Node) *Node {
+	if !node.boxed {
+		return node
+	}
+	res := *node
+	res.boxed = false
+	return &res
+}
+
+// convertConst converts an untyped integer constant to the integer type t.
+// Unlike conversions at runtime, constants that do not fit are an error.
+func convertConst(node *Node, t types.Type) (*Node, error) {
+	val := constInt(node)
+	bits := uint(t.Bits())
+	var fits bool
+	if t.Kind() == types.Int {
+		rest := new(big.Int).Rsh(val, bits-1)
+		fits = rest.Sign() == 0 || rest.IsInt64() && rest.Int64() == -1
+	} else {
+		fits = val.Sign() >= 0 && new(big.Int).Rsh(val, bits).Sign() == 0
+	}
+	if !fits {
+		return nil, errors.Errorf("constant %v overflows %v", val, t)
+	}
+
+	if t.Kind() == types.Int {
+		return &Node{Type: t, Value: val.Int64()}, nil
+	}
+	return &Node{Type: t, Value: val.Uint64()}, nil
+}
+
+// convertTo converts node to the type t like the conversion t(node). Unlike
+// promoteTo, it can change the kind of a value, like truncating floats to
+// integers.
+func convertTo(node *Node, t types.Type) (*Node, error) {
+	to := t.Underlying()
+	switch {
+	case isUntypedNil(node) || to.Kind() == types.Interface:
+		return promoteTo(node, t)
+	case node.untyped && (to.Kind() == types.Int || to.Kind() == types.Uint):
+		// constants still have to fit
+		res, err := convertConst(node, to)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Type: t, Value: res.Value, constant: true}, nil
+	case node.Type.Underlying().Equal(to):
+		return &Node{Type: t, Value: node.Value}, nil
+	}
+
+	from := node.Type.Underlying()
+	switch {
+	case from.Kind().IsNumeric() && to.Kind() == types.Int:
+		val, _ := node.ToInt()
+		return newIntegerNode(t, val), nil
+	case from.Kind().IsNumeric() && to.Kind() == types.Uint:
+		val, _ := node.ToUint()
+		return newIntegerNode(t, val), nil
+	case from.Kind().IsNumeric() && to.Kind() == types.Float:
+		val, _ := node.ToFloat()
+		return &Node{Type: t, Value: val}, nil
+	case (from.Kind() == types.Int || from.Kind() == types.Uint) &&
+		to.Kind() == types.String:
+		// string(65) is "A"
+		val, err := node.ToInt()
+		if err != nil || val != int64(rune(val)) {
+			val = utf8.RuneError
+		}
+		return &Node{Type: t, Value: string(rune(val))}, nil
+	case from.Kind() == types.String && isByteOrRuneSlice(to):
+		return stringToSlice(node.Value.(string), t), nil
+	case isByteOrRuneSlice(from) && to.Kind() == types.String:
+		return sliceToString(node.Value.([]*Node), t), nil
+	}
+
+	return nil, errors.Errorf("cannot convert %v to %v", node.Type, t)
+}
+
+// isByteOrRuneSlice reports whether t is a slice of bytes or runes, which can
+// be converted to and from strings.
+func isByteOrRuneSlice(t types.Type) bool {
+	if t.Kind() != types.Array {
+		return false
+	}
+	elem, _ := t.Elem()
+	elem = elem.Underlying()
+	return elem.Kind() == types.Uint && elem.Bits() == 8 ||
+		elem.Kind() == types.Int && elem.Bits() == 32
+}
+
+// isByteSlice reports whether t is a slice of bytes, which strings can be
+// copied and appended to.
+func isByteSlice(t types.Type) bool {
+	t = t.Underlying()
+	if t.Kind() != types.Array {
+		return false
+	}
+	elem, _ := t.Elem()
+	elem = elem.Underlying()
+	return elem.Kind() == types.Uint && elem.Bits() == 8
+}
+
+// stringToSlice converts a string to the slice of bytes or runes t.
+func stringToSlice(str string, t types.Type) *Node {
+	elemType, _ := t.Elem()
+	var elems []*Node
+	if elemType.Kind() == types.Uint {
+		for _, b := range []byte(str) {
+			elems = append(elems, &Node{Type: elemType, Value: uint64(b)})
+		}
+	} else {
+		for _, r := range str {
+			elems = append(elems, &Node{Type: elemType, Value: int64(r)})
+		}
+	}
+	if elems == nil {
+		elems = []*Node{}
+	}
+	return &Node{Type: t, Value: elems}
+}
+
+// sliceToString converts a slice of bytes or runes to a string of type t.
+func sliceToString(elems []*Node, t types.Type) *Node {
+	var b strings.Builder
+	for _, elem := range elems {
+		switch val := elem.Value.(type) {
+		case uint64:
+			b.WriteByte(byte(val))
+		case int64:
+			b.WriteRune(rune(val))
+		}
+	}
+	return &Node{Type: t, Value: b.String()}
+}
+
+// defaultType gives an untyped constant the type it has when it is stored in a
+// variable, which is int for integers and int32 for runes.
+func defaultType(node *Node) (*Node, error) {
+	if !node.untyped {
+		return dropConst(node), nil
+	}
+	if isUntypedNil(node) {
+		return nil, errors.New("use of untyped nil")
+	}
+	return convertConst(node, node.Type)
+}
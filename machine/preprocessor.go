@@ -5,10 +5,8 @@ import (
 	"go/ast"
 	"go/token"
 	"reflect"
-	"strconv"
 
 	"github.com/go-errors/errors"
-	"github.com/podocarp/goscript/types"
 	"golang.org/x/tools/go/ast/astutil"
 )
 
@@ -17,6 +15,11 @@ func (m *Machine) Preprocess(expr ast.Node) error {
 	astutil.Apply(
 		expr,
 		func(c *astutil.Cursor) bool {
+			if err != nil {
+				// stop at the first error
+				return false
+			}
+
 			var newexpr ast.Node
 			expr := c.Node()
 			if m.debugFlag {
@@ -26,12 +29,21 @@ func (m *Machine) Preprocess(expr ast.Node) error {
 			}
 			switch n := expr.(type) {
 			case *ast.BasicLit:
-				newexpr, err = m.preprocessBasicLit(n)
-				c.Replace(newexpr)
+				switch c.Parent().(type) {
+				case *ast.Field, *ast.ImportSpec:
+					// struct tags and import paths stay literals
+				default:
+					newexpr, err = m.preprocessBasicLit(n)
+					if err == nil {
+						c.Replace(newexpr)
+					}
+				}
 			case *ast.Ident:
 				err = m.preprocessIdent(n)
 			case *ast.FuncLit:
-				err = m.preprocessFuncLit(n)
+				err = m.preprocessFuncType(n.Type)
+			case *ast.FuncDecl:
+				err = m.preprocessFuncType(n.Type)
 			}
 
 			if m.debugFlag {
@@ -46,26 +58,171 @@ func (m *Machine) Preprocess(expr ast.Node) error {
 		},
 		nil,
 	)
+	if err != nil {
+		return err
+	}
+
+	return checkConstAssign(expr)
+}
+
+// constChecker keeps track of which names refer to constants in each scope of
+// a script.
+type constChecker struct {
+	// maps names to whether they are constants, innermost scope last
+	scopes []map[string]bool
+}
+
+func (c *constChecker) push() {
+	c.scopes = append(c.scopes, map[string]bool{})
+}
+
+func (c *constChecker) pop() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *constChecker) declare(name string, isConst bool) {
+	c.scopes[len(c.scopes)-1][name] = isConst
+}
+
+// declareFields declares the names of parameters and results.
+func (c *constChecker) declareFields(fieldLists ...*ast.FieldList) {
+	for _, fields := range fieldLists {
+		if fields == nil {
+			continue
+		}
+		for _, field := range fields.List {
+			for _, name := range field.Names {
+				c.declare(name.Name, false)
+			}
+		}
+	}
+}
+
+func (c *constChecker) isConst(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if isConst, ok := c.scopes[i][ident.Name]; ok {
+			return isConst
+		}
+	}
+	return false
+}
+
+// opensScope reports whether a node starts a new scope.
+func opensScope(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.BlockStmt, *ast.CaseClause, *ast.ForStmt, *ast.FuncDecl, *ast.FuncLit,
+		*ast.IfStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkConstAssign returns an error if a script assigns to a constant.
+func checkConstAssign(expr ast.Node) error {
+	c := &constChecker{}
+	c.push()
+
+	// declarations in a file are all in scope in the bodies of its
+	// functions, wherever they are
+	if file, ok := expr.(*ast.File); ok {
+		for _, decl := range file.Decls {
+			if decl, ok := decl.(*ast.GenDecl); ok && decl.Tok == token.CONST {
+				for _, spec := range decl.Specs {
+					for _, name := range spec.(*ast.ValueSpec).Names {
+						c.declare(name.Name, true)
+					}
+				}
+			}
+		}
+	}
+
+	var err error
+	astutil.Apply(
+		expr,
+		func(cur *astutil.Cursor) bool {
+			n := cur.Node()
+			if opensScope(n) {
+				c.push()
+			}
+
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				c.declareFields(n.Type.Params, n.Type.Results)
+			case *ast.FuncDecl:
+				c.declareFields(n.Recv, n.Type.Params, n.Type.Results)
+			case *ast.RangeStmt:
+				if n.Tok == token.DEFINE {
+					for _, expr := range []ast.Expr{n.Key, n.Value} {
+						if ident, ok := expr.(*ast.Ident); ok {
+							c.declare(ident.Name, false)
+						}
+					}
+				}
+			case *ast.AssignStmt:
+				if n.Tok == token.DEFINE {
+					break
+				}
+				for _, lhs := range n.Lhs {
+					if c.isConst(lhs) {
+						err = errors.Errorf("cannot assign to constant %v", lhs)
+					}
+				}
+			case *ast.IncDecStmt:
+				if c.isConst(n.X) {
+					err = errors.Errorf("cannot assign to constant %v", n.X)
+				}
+			case *ast.UnaryExpr:
+				if n.Op == token.AND && c.isConst(n.X) {
+					err = errors.Errorf("cannot take address of constant %v", n.X)
+				}
+			}
+
+			return err == nil
+		},
+		func(cur *astutil.Cursor) bool {
+			n := cur.Node()
+			if opensScope(n) {
+				c.pop()
+			}
+
+			// names are only in scope after their declaration
+			switch n := n.(type) {
+			case *ast.AssignStmt:
+				if n.Tok != token.DEFINE {
+					break
+				}
+				for _, lhs := range n.Lhs {
+					if ident, ok := lhs.(*ast.Ident); ok {
+						c.declare(ident.Name, false)
+					}
+				}
+			case *ast.GenDecl:
+				if n.Tok != token.CONST && n.Tok != token.VAR {
+					break
+				}
+				for _, spec := range n.Specs {
+					for _, name := range spec.(*ast.ValueSpec).Names {
+						c.declare(name.Name, n.Tok == token.CONST)
+					}
+				}
+			}
+			return true
+		},
+	)
 
 	return err
 }
 
 // preprocessBasicLit converts literals into machine.Nodes beforehand
 func (m *Machine) preprocessBasicLit(lit *ast.BasicLit) (ast.Node, error) {
-	var node *Node
-	switch lit.Kind {
-	case token.FLOAT:
-		val, _ := strconv.ParseFloat(lit.Value, 64)
-		node = NewFloatNode(val)
-	case token.INT:
-		val, _ := strconv.ParseInt(lit.Value, 10, 64)
-		node = NewIntNode(val)
-	case token.CHAR, token.STRING:
-		val, _ := strconv.Unquote(lit.Value)
-		node = &Node{
-			Type:  types.StringType,
-			Value: val,
-		}
+	node, err := m.evalLit(lit)
+	if err != nil {
+		return nil, err
 	}
 
 	return &ast.Ident{
@@ -88,6 +245,11 @@ func (m *Machine) preprocessIdent(lit *ast.Ident) error {
 			Data: NewBoolNode(false),
 		}
 		return nil
+	case "nil":
+		lit.Obj = &ast.Object{
+			Data: newNilNode(),
+		}
+		return nil
 	}
 
 	return nil
@@ -106,8 +268,13 @@ func flattenArgList(fieldList []*ast.Field) ([]*ast.Field, error) {
 		if newField.Names == nil || len(newField.Names) == 0 {
 			// in this case the Type element is used to store the
 			// name of the field
-			name := newField.Type.(*ast.Ident)
+			name, ok := newField.Type.(*ast.Ident)
+			if !ok {
+				return nil, errors.New("parameters must be named")
+			}
 			newField.Names = []*ast.Ident{name}
+			// untyped parameters accept anything
+			newField.Type = nil
 			res = append(res, &newField)
 			continue
 		}
@@ -128,14 +295,19 @@ func flattenArgList(fieldList []*ast.Field) ([]*ast.Field, error) {
 	return res, nil
 }
 
-// preprocessFuncLit preprocesses the argument list and makes it easier to
+// preprocessFuncType preprocesses the argument list and makes it easier to
 // traverse
-func (m *Machine) preprocessFuncLit(lit *ast.FuncLit) error {
-	params := lit.Type.Params
+func (m *Machine) preprocessFuncType(ft *ast.FuncType) error {
+	params := ft.Params
 	fieldList, err := flattenArgList(params.List)
 	if err != nil {
 		return err
 	}
-	lit.Type.Params.List = fieldList
+	for i, field := range fieldList {
+		if isVariadic(field) && i != len(fieldList)-1 {
+			return errors.New("can only use ... with final parameter in list")
+		}
+	}
+	ft.Params.List = fieldList
 	return nil
 }
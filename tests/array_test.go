@@ -181,7 +181,7 @@ func TestArrayMake(t *testing.T) {
 	`
 	res, err = m.ParseAndEval(stmt)
 	require.Nil(t, err, err)
-	require.EqualValues(t, make([]*machine.Node, 10), res.Value)
+	require.EqualValues(t, make([]float64, 10), res.NodeToValue().Interface())
 	expectedType = types.ArrayOf(types.FloatType)
 	require.True(t, res.Type.Equal(expectedType), res.Type)
 
@@ -192,7 +192,8 @@ func TestArrayMake(t *testing.T) {
 	`
 	res, err = m.ParseAndEval(stmt)
 	require.Nil(t, err, err)
-	require.EqualValues(t, make([]*machine.Node, 2, 10), res.Value)
+	require.EqualValues(t, []string{"", ""}, res.NodeToValue().Interface())
+	require.EqualValues(t, 10, cap(res.Value.([]*machine.Node)))
 	expectedType = types.ArrayOf(types.StringType)
 	require.True(t, res.Type.Equal(expectedType), res.Type)
 }
@@ -283,4 +284,158 @@ func TestArrayLen(t *testing.T) {
 	res, err = m.ParseAndEval(stmt)
 	require.Nil(t, err, err)
 	require.EqualValues(t, 3, res.Value)
+
+	failingStmts := []string{
+		`len()`,
+		`len([]int{}, []int{})`,
+		`len(1)`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestArraySlice tests slice expressions and cap()
+func TestArraySlice(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		c := []int{0, 1, 2, 3, 4, 5}
+		a := c[1:4]
+		b := c[:2]
+		d := c[4:]
+		e := c[1:3:4]
+		return len(a), cap(a), a[0], len(b), cap(b), len(d), d[1], len(e), cap(e)
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	vals := make([]int64, len(res.Elems))
+	for i, elem := range res.Elems {
+		vals[i] = elem.Value.(int64)
+	}
+	require.EqualValues(t, []int64{3, 5, 1, 2, 6, 2, 5, 2, 3}, vals)
+
+	// slices can be extended up to their capacity
+	stmt = `func() {
+		c := []int{0, 1, 2, 3}
+		a := c[:1]
+		a = a[1:3]
+		return a[1]
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Value)
+
+	stmts := []string{
+		`func() {
+			c := []int{0, 1, 2}
+			return c[2:5]
+		}()`,
+		`func() {
+			c := []int{0, 1, 2}
+			return c[2:1]
+		}()`,
+		`func() {
+			c := []int{0, 1, 2}
+			return c[0:1:4]
+		}()`,
+		`func() {
+			c := []int{0, 1, 2}
+			return c[3]
+		}()`,
+	}
+	for _, stmt := range stmts {
+		_, err = m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestArrayAliasing tests that slices share their backing array like in go
+func TestArrayAliasing(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		c := []int{0, 1, 2, 3}
+		a := c[1:3]
+		a[0] = 10
+
+		// there is room in the backing array, so c sees the append
+		a = append(a, 20)
+
+		// there is no more room, so b gets a new backing array
+		b := append(c, 30)
+		b[0] = 40
+		return c[1], c[3], len(c), b[0], c[0]
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 10, res.Elems[0].Value)
+	require.EqualValues(t, 20, res.Elems[1].Value)
+	require.EqualValues(t, 4, res.Elems[2].Value)
+	require.EqualValues(t, 40, res.Elems[3].Value)
+	require.EqualValues(t, 0, res.Elems[4].Value)
+
+	stmt = `func() {
+		c := make([]int, 0, 2)
+		c = append(c, 1)
+		d := append(c, 2)
+		e := append(c, 3)
+		return len(c), cap(c), d[1]
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Elems[0].Value)
+	require.EqualValues(t, 2, res.Elems[1].Value)
+	require.EqualValues(t, 3, res.Elems[2].Value)
+}
+
+// TestArrayCopy tests that copy() works as expected
+func TestArrayCopy(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		src := []int{1, 2, 3}
+		dst := []int{0, 0}
+		n := copy(dst, src)
+
+		// overlapping copies work
+		c := []int{1, 2, 3, 4}
+		copy(c[1:], c)
+		return n, dst[1], c[1], c[3]
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Elems[0].Value)
+	require.EqualValues(t, 2, res.Elems[1].Value)
+	require.EqualValues(t, 1, res.Elems[2].Value)
+	require.EqualValues(t, 3, res.Elems[3].Value)
+
+	// strings can be copied and appended to slices of bytes
+	stmt = `func() {
+		b := make([]byte, 2)
+		n := copy(b, "hey")
+		b = append(b, "lo"...)
+		return n, string(b)
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Elems[0].Value)
+	require.EqualValues(t, "helo", res.Elems[1].Value)
+
+	failingStmts := []string{
+		`copy([]int{1}, []float64{1})`,
+		`copy([]int{1}, "a")`,
+		`append([]rune{}, "a"...)`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
 }
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAssignOp tests assignment operators on indexes, fields and pointers
+func TestAssignOp(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		type Point struct { X, Y int }
+		arr := []int{1, 2, 3}
+		arr[0] += 10
+		arr[1]++
+		arr[2] <<= 2
+
+		counts := map[string]int{}
+		counts["a"] += 2
+		counts["a"]++
+		counts["b"]--
+
+		p := &Point{1, 2}
+		p.X += 4
+		p.Y *= 3
+		n := 5
+		q := &n
+		*q -= 2
+		(*q)++
+		return arr, counts["a"], counts["b"], p.X, p.Y, n
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, []int{11, 3, 12}, res.Elems[0].NodeToValue().Interface())
+	require.EqualValues(t, 3, res.Elems[1].Value)
+	require.EqualValues(t, -1, res.Elems[2].Value)
+	require.EqualValues(t, 5, res.Elems[3].Value)
+	require.EqualValues(t, 6, res.Elems[4].Value)
+	require.EqualValues(t, 4, res.Elems[5].Value)
+
+	// the left side is only evaluated once
+	stmt = `func() {
+		calls := 0
+		next := func() int {
+			calls++
+			return calls
+		}
+		arr := []int{0, 0, 0}
+		arr[next()] += 5
+		arr[next()]++
+		return arr, calls
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, []int{0, 5, 1}, res.Elems[0].NodeToValue().Interface())
+	require.EqualValues(t, 2, res.Elems[1].Value)
+}
+
+// TestAssignTuple tests assigning several values at once
+func TestAssignTuple(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		arr := []int{1, 2, 3}
+		arr[0], arr[2] = arr[2], arr[0]
+
+		// indexes on the left are evaluated before any assignment
+		i := 0
+		i, arr[i] = 1, 10
+
+		pair := func() (int, string) { return 7, "seven" }
+		_, s := pair()
+		n, _ := pair()
+		_ = n
+		return arr, i, s, n
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, []int{10, 2, 1}, res.Elems[0].NodeToValue().Interface())
+	require.EqualValues(t, 1, res.Elems[1].Value)
+	require.EqualValues(t, "seven", res.Elems[2].Value)
+	require.EqualValues(t, 7, res.Elems[3].Value)
+
+	failingStmts := []string{
+		`func() { arr := []int{1}; arr[1] += 1 }()`,
+		`func() { arr := []int{1}; arr[-1]++ }()`,
+		`func() { arr := []int{1}; arr[2], arr[0] = 1, 2 }()`,
+		`func() { arr := []int{1}; arr[undefined] = 1 }()`,
+		`func() { s := "abc"; s[0] = 'x' }()`,
+		`func() { s := "abc"; s[0]++ }()`,
+		`func() { var m map[string]int; m["a"] += 1 }()`,
+		`func() { arr := []int{1}; arr[0] += "a" }()`,
+		`func() { x := "a"; x -= "b" }()`,
+		`func() { _ += 1 }()`,
+		`func() { a, b := 1, 2; a, b = 3 }()`,
+		`func() { a := 1; a += 1, 2 }()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
@@ -23,6 +23,29 @@ func TestBasicArithmetic(t *testing.T) {
 	val, err = m.ParseAndEval(stmt)
 	require.Nil(t, err, err)
 	require.EqualValues(t, 13, val.Value)
+
+	// variables without a declared type take the type of what is assigned
+	// to them, while constants take the type of the variable
+	stmt = `func() {
+		c := []float64{0.5, 1, 1.5}
+		res := 0
+		for i := range c {
+			res = res + c[i]
+		}
+		n := 1
+		n = 2
+		var b byte = 1
+		b = 200
+		return res, n, b
+	}()`
+	val, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3.0, val.Elems[0].Value)
+	require.EqualValues(t, 2, val.Elems[1].Value)
+	require.EqualValues(t, uint8(200), val.Elems[2].NodeToValue().Interface())
+
+	_, err = m.ParseAndEval(`func() { var b byte = 1; b = 256 }()`)
+	require.NotNil(t, err)
 }
 
 func TestMultiAssign(t *testing.T) {
@@ -52,3 +75,160 @@ func TestMultiAssign(t *testing.T) {
 	require.EqualValues(t, 3, val.Elems[1].Value)
 	require.EqualValues(t, 4, val.Elems[2].Value)
 }
+
+// TestBitwise tests bitwise and shift operators
+func TestBitwise(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		a := 12 & 10
+		b := 12 | 3
+		c := 12 ^ 10
+		d := 12 &^ 4
+		e := 1 << 4
+		f := -16 >> 2
+		g := ^5
+		return a, b, c, d, e, f, g
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	vals := make([]int64, len(res.Elems))
+	for i, elem := range res.Elems {
+		vals[i] = elem.Value.(int64)
+	}
+	require.EqualValues(t, []int64{8, 15, 6, 8, 16, -4, -6}, vals)
+
+	// op-assign forms
+	stmt = `func() {
+		flags := 0
+		flags |= 1 << 3
+		flags |= 1
+		flags &= ^1
+		flags ^= 3
+		flags <<= 2
+		flags >>= 1
+		flags &^= 2
+		return flags
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 20, res.Value)
+
+	// unsigned words from the host
+	err = m.AddToGlobalContext("word", uint64(0xF0F0))
+	require.Nil(t, err, err)
+	res, err = m.ParseAndEval("(word >> 4) | word")
+	require.Nil(t, err, err)
+	require.EqualValues(t, uint64(0xFFFF), res.Value)
+	res, err = m.ParseAndEval("^word")
+	require.Nil(t, err, err)
+	require.EqualValues(t, ^uint64(0xF0F0), res.Value)
+
+	stmts := []string{
+		"1.5 & 1",
+		"1 | 2.5",
+		"1.5 << 2",
+		"1 << -1",
+		"^1.5",
+		`word & len("a")`,
+	}
+	for _, stmt := range stmts {
+		_, err = m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestSizedIntegers tests that sized integers are distinct types that wrap
+// around on overflow
+func TestSizedIntegers(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		var a int8 = 127
+		a++
+		var b uint8 = 0
+		b--
+		var c uint16 = 300
+		c *= 300
+		var d int32 = -2147483648
+		d = -d
+		var e uint32 = 1
+		return a, b, c, d, ^e, e << 32
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, int8(-128), res.Elems[0].NodeToValue().Interface())
+	require.EqualValues(t, uint8(255), res.Elems[1].NodeToValue().Interface())
+	require.EqualValues(t, uint16(24464), res.Elems[2].NodeToValue().Interface())
+	require.EqualValues(t, int32(-2147483648), res.Elems[3].NodeToValue().Interface())
+	require.EqualValues(t, uint32(0xFFFFFFFE), res.Elems[4].NodeToValue().Interface())
+	require.EqualValues(t, uint32(0), res.Elems[5].NodeToValue().Interface())
+
+	// constants take the type of the other operand
+	stmt = `func(x byte, y uint64) {
+		return x + 'a', y / 2, x > 100
+	}(1, 18446744073709551615)`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, uint8('b'), res.Elems[0].NodeToValue().Interface())
+	require.EqualValues(t, uint64(9223372036854775807), res.Elems[1].Value)
+	require.EqualValues(t, false, res.Elems[2].Value)
+
+	stmts := []string{
+		// mismatched types
+		`func(a int8, b int16) { return a + b }(1, 2)`,
+		`func(a int, b int64) { return a == b }(1, 2)`,
+		`func(a uint, b int) { return a & b }(1, 2)`,
+		`func(a int8) { return a }(len("a"))`,
+		// constants that do not fit
+		`func() { var a int8 = 128 }()`,
+		`func() { var a uint = -1 }()`,
+		`func(a uint8) { return a + 256 }(1)`,
+		`func() { a := 18446744073709551615 }()`,
+		// division by zero
+		`func(a int) { return a / 0 }(1)`,
+		`func(a uint8) { return a % 0 }(1)`,
+	}
+	for _, stmt := range stmts {
+		_, err = m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestIntegerLiterals tests the different ways of writing integer literals
+func TestIntegerLiterals(t *testing.T) {
+	m := machine.NewMachine()
+
+	tests := map[string]any{
+		"0x_FF":      255,
+		"0XfF":       255,
+		"0o17":       15,
+		"017":        15,
+		"0b1010":     10,
+		"1_000_000":  1000000,
+		"'a'":        'a',
+		"'\\n'":      '\n',
+		"'\\x41'":    'A',
+		"1e3":        1000.0,
+		"0x1p-2":     0.25,
+		"1_0.2_5":    10.25,
+		"1 << 62":    int64(1) << 62,
+		"-1 << 63":   int64(-1) << 63,
+		"0xFFFFFFFF": 4294967295,
+	}
+	for stmt, expected := range tests {
+		res, err := m.ParseAndEval(stmt)
+		require.Nil(t, err, err)
+		require.EqualValues(t, expected, res.Value, stmt)
+	}
+
+	stmts := []string{
+		"99999999999999999999",
+		"1e400",
+		"1 + 2i",
+	}
+	for _, stmt := range stmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
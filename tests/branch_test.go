@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBreak tests that break stops the innermost loop
+func TestBreak(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		first := -1
+		for i, v := range []int{3, 8, 1, 9} {
+			if v > 5 {
+				first = i
+				break
+			}
+		}
+
+		count := 0
+		for {
+			count++
+			if count == 4 {
+				break
+			}
+		}
+
+		sum := 0
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 10; j++ {
+				if j == 2 {
+					break
+				}
+				sum++
+			}
+		}
+		return first, count, sum
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Elems[0].Value)
+	require.EqualValues(t, 4, res.Elems[1].Value)
+	require.EqualValues(t, 6, res.Elems[2].Value)
+
+	// range over maps and channels
+	stmt = `func() {
+		c := make(chan int, 5)
+		for i := 0; i < 5; i++ {
+			c <- i
+		}
+		close(c)
+		sum := 0
+		for v := range c {
+			if v == 1 {
+				continue
+			}
+			if v == 3 {
+				break
+			}
+			sum += v
+		}
+
+		count := 0
+		for range map[int]int{1: 1, 2: 2, 3: 3} {
+			count++
+			break
+		}
+		return sum, count
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Elems[0].Value)
+	require.EqualValues(t, 1, res.Elems[1].Value)
+}
+
+// TestLabels tests labeled break and continue across nested statements
+func TestLabels(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		grid := [][]int{ {1, 2, 3}, {4, -1, 6}, {7, 8, 9} }
+		sum := 0
+	outer:
+		for _, row := range grid {
+			for _, v := range row {
+				if v < 0 {
+					break outer
+				}
+				sum += v
+			}
+		}
+
+		skipped := 0
+	rows:
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				switch {
+				case j > i:
+					skipped++
+					continue rows
+				}
+			}
+		}
+		return sum, skipped
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 10, res.Elems[0].Value)
+	require.EqualValues(t, 2, res.Elems[1].Value)
+
+	// breaking out of a labeled switch from a loop inside it
+	stmt = `func() {
+		n := 0
+	sw:
+		switch {
+		default:
+			for {
+				n++
+				if n == 3 {
+					break sw
+				}
+			}
+		}
+		return n
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3, res.Value)
+
+	stmts := []string{
+		`func() {
+			for {
+				break nowhere
+			}
+		}()`,
+		`func() {
+			break
+		}()`,
+	}
+	for _, stmt := range stmts {
+		_, err = m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestGoto tests jumping to labels in the same or enclosing blocks
+func TestGoto(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		i := 0
+		sum := 0
+	loop:
+		if i < 5 {
+			sum += i
+			i++
+			goto loop
+		}
+		return sum
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 10, res.Value)
+
+	stmt = `func() {
+		found := -1
+		for i := 0; i < 10; i++ {
+			if i*i > 20 {
+				found = i
+				goto done
+			}
+		}
+		found = 100
+	done:
+		return found
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 5, res.Value)
+
+	stmt = `func() {
+		goto missing
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
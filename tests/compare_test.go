@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCompareBasic tests comparing bools, strings and numbers
+func TestCompareBasic(t *testing.T) {
+	m := machine.NewMachine()
+
+	tests := map[string]bool{
+		"true == true":  true,
+		"true != false": true,
+		"false == true": false,
+		`"a" == "a"`:    true,
+		`"a" >= "b"`:    false,
+		"2 >= 2":        true,
+		"2 >= 3":        false,
+		"2.5 >= 2":      true,
+		"1 <= 1.0":      true,
+	}
+	for stmt, expected := range tests {
+		res, err := m.ParseAndEval(stmt)
+		require.Nil(t, err, err)
+		require.EqualValues(t, expected, res.Value, stmt)
+	}
+
+	stmt := `func() {
+		flag := 1 > 0
+		if flag == true {
+			return "yes"
+		}
+		return "no"
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, "yes", res.Value)
+}
+
+// TestCompareComposite tests comparing structs, pointers and channels
+func TestCompareComposite(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		type Point struct { X, Y int; Name string }
+		a := Point{1, 2, "a"}
+		b := Point{1, 2, "a"}
+		c := Point{1, 3, "a"}
+		return a == b, a != b, a == c, a != c
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, true, res.Elems[0].Value)
+	require.EqualValues(t, false, res.Elems[1].Value)
+	require.EqualValues(t, false, res.Elems[2].Value)
+	require.EqualValues(t, true, res.Elems[3].Value)
+
+	stmt = `func() {
+		x := 1
+		y := 1
+		p := &x
+		q := &x
+		r := &y
+		c := make(chan int)
+		d := c
+		e := make(chan int)
+		return p == q, p == r, c == d, c == e
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, true, res.Elems[0].Value)
+	require.EqualValues(t, false, res.Elems[1].Value)
+	require.EqualValues(t, true, res.Elems[2].Value)
+	require.EqualValues(t, false, res.Elems[3].Value)
+
+	// values in interfaces are equal only if their types are too
+	stmt = `func() {
+		type Box struct { V any }
+		return Box{1} == Box{1}, Box{1} == Box{"1"}
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, true, res.Elems[0].Value)
+	require.EqualValues(t, false, res.Elems[1].Value)
+
+	failingStmts := []string{
+		`true < false`,
+		`true == 1`,
+		`"a" == 1`,
+		`[]int{1} == []int{1}`,
+		`map[int]int{} != map[int]int{}`,
+		`func() {} == func() {}`,
+		`func() {
+			type Bag struct { Items []int }
+			return Bag{} == Bag{}
+		}()`,
+		`func() {
+			type Box struct { V any }
+			return Box{[]int{}} == Box{[]int{}}
+		}()`,
+		`func() {
+			type Point struct { X, Y int }
+			return Point{} < Point{}
+		}()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/podocarp/goscript/types"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConstDefine tests const declarations with iota and implicit repetition
+func TestConstDefine(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		const limit = 10
+		const (
+			low = iota
+			mid
+			high
+		)
+		const (
+			_ = iota * 10
+			ten
+			twenty
+		)
+		return limit, low, mid, high, ten, twenty
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	vals := make([]int64, len(res.Elems))
+	for i, elem := range res.Elems {
+		vals[i] = elem.Value.(int64)
+	}
+	require.EqualValues(t, []int64{10, 0, 1, 2, 10, 20}, vals)
+
+	// typed constants and multiple names per line
+	stmt = `func() {
+		const (
+			a, b float64 = iota, iota + 1
+			c, d
+		)
+		const name string = "x"
+		return a, b, c, d, name
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 0, res.Elems[0].Value)
+	require.Equal(t, types.Float, res.Elems[0].Type.Kind())
+	require.EqualValues(t, 1, res.Elems[1].Value)
+	require.EqualValues(t, 1, res.Elems[2].Value)
+	require.EqualValues(t, 2, res.Elems[3].Value)
+	require.EqualValues(t, "x", res.Elems[4].Value)
+
+	// iota is not visible outside of const declarations
+	stmt = `func() {
+		const a = 1
+		return iota
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
+
+// TestConstAssign tests that constants cannot be changed
+func TestConstAssign(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmts := []string{
+		`func() {
+			const a = 1
+			a = 2
+		}()`,
+		`func() {
+			const a = 1
+			a++
+		}()`,
+		`func() {
+			const a = 1
+			if true {
+				a += 2
+			}
+		}()`,
+		`func() {
+			const a = 1
+			return &a
+		}()`,
+	}
+	for _, stmt := range stmts {
+		_, err := m.Parse(stmt)
+		require.NotNil(t, err, stmt)
+	}
+
+	// variables can shadow constants
+	stmt := `func() {
+		const a = 1
+		if true {
+			a := 2
+			a = 3
+		}
+		f := func(a int) {
+			a = 4
+			return a
+		}
+		return a, f(0)
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Elems[0].Value)
+	require.EqualValues(t, 4, res.Elems[1].Value)
+
+	// constants in a file can be used before their declaration
+	_, err = m.Parse(`
+func set() { X = 5 }
+
+const X = 1
+`)
+	require.NotNil(t, err)
+
+	// constants declared by an earlier script are only found at runtime
+	_, err = m.ParseAndEval(`const Y = 1`)
+	require.Nil(t, err, err)
+	stmts = []string{
+		`func() { Y = 2 }()`,
+		`func() { Y++ }()`,
+		`func() { return &Y }()`,
+	}
+	for _, stmt := range stmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+	res, err = m.ParseAndEval(`Y`)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Value)
+}
+
+// TestConstOverflow tests that operations on typed constants are checked for
+// overflow, while the same operations on variables wrap around
+func TestConstOverflow(t *testing.T) {
+	m := machine.NewMachine()
+
+	_, err := m.ParseAndEval(`const (
+		A int8 = 100
+		B
+	)
+	const U uint8 = 1`)
+	require.Nil(t, err, err)
+
+	stmts := []string{
+		`B + 100`,
+		`A * 2`,
+		`A << 1`,
+		`U - 2`,
+		`-U`,
+		`int8(100) + 100`,
+	}
+	for _, stmt := range stmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+
+	stmt := `func() {
+		x := B
+		var y int8 = A
+		y += 100
+		return x + 100, y, A - 1, ^U
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, -56, res.Elems[0].Value)
+	require.EqualValues(t, -56, res.Elems[1].Value)
+	require.EqualValues(t, 99, res.Elems[2].Value)
+	require.EqualValues(t, 254, res.Elems[3].Value)
+}
+
+// TestConstExact tests that untyped constants are exact, and only have to fit
+// once they are given a type
+func TestConstExact(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		const Big = 1 << 100
+		const Min = -9223372036854775808
+		var u uint64 = 1 << 63
+		var x int64 = -9223372036854775808
+		var y int64 = Big >> 98
+		return Min < 0, u, x, y, Big / (1 << 90)
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, true, res.Elems[0].Value)
+	require.EqualValues(t, uint64(1)<<63, res.Elems[1].Value)
+	require.EqualValues(t, int64(-9223372036854775808), res.Elems[2].Value)
+	require.EqualValues(t, 4, res.Elems[3].Value)
+	require.EqualValues(t, 1024, res.Elems[4].Value)
+
+	stmts := []string{
+		`func() { x := 1 << 63 }()`,
+		`9223372036854775807 * 2`,
+		`func() { const Big = 1 << 100; return Big }()`,
+		`func(a) { return a }(1 << 64)`,
+		`func() { var a uint64 = -1 << 63 }()`,
+		`1 << 1000`,
+		`1 / 0`,
+	}
+	for _, stmt := range stmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConversions tests converting values between types
+func TestConversions(t *testing.T) {
+	m := machine.NewMachine()
+
+	tests := map[string]any{
+		"int(2.9)":                     int64(2),
+		"int(-2.9)":                    int64(-2),
+		"uint8(3.5)":                   uint64(3),
+		"float64(3) / 2":               1.5,
+		"int8(200 + len(\"\"))":        int64(-56),
+		"uint16(int32(-1))":            uint64(65535),
+		"int64(1 << 40)":               int64(1 << 40),
+		"string(65)":                   "A",
+		"string(rune(0x4e16))":         "世",
+		"string(-1)":                   "�",
+		`string([]byte("héllo"))`:      "héllo",
+		`len([]byte("héllo"))`:         int64(6),
+		`len([]rune("héllo"))`:         int64(5),
+		`string([]rune("héllo")[1:3])`: "él",
+		`[]byte("a")[0]`:               uint64('a'),
+		"func(x float64) int { return int(x * 10) }(1.25)": int64(12),
+	}
+	for stmt, expected := range tests {
+		res, err := m.ParseAndEval(stmt)
+		require.Nil(t, err, err)
+		require.EqualValues(t, expected, res.Value, stmt)
+	}
+
+	// conversions between declared types with the same underlying type
+	stmt := `func() {
+		type Celsius float64
+		type Fahrenheit float64
+		type Point struct { X, Y int }
+		type Vec struct { X, Y int }
+		c := Celsius(100)
+		f := Fahrenheit(c*9/5 + 32)
+		v := Vec(Point{1, 2})
+		var x any = float64(f)
+		p := (*int)(nil)
+		return f, v.Y, x, p
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 212, res.Elems[0].Value)
+	require.EqualValues(t, "Fahrenheit", res.Elems[0].Type.String())
+	require.EqualValues(t, 2, res.Elems[1].Value)
+	require.EqualValues(t, "float", res.Elems[2].Type.String())
+	require.EqualValues(t, "*int", res.Elems[3].Type.String())
+
+	failingStmts := []string{
+		// impossible conversions
+		`int("1")`,
+		`string(1.5)`,
+		`float64("1")`,
+		`bool(1)`,
+		`[]int("abc")`,
+		`func() { type Point struct { X int }; return Point(1) }()`,
+		// constants that do not fit
+		`int8(128)`,
+		`uint(-1)`,
+		// wrong number of arguments
+		`int(1, 2)`,
+		`int()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestConversionsInGenerics tests conversions to type parameters
+func TestConversionsInGenerics(t *testing.T) {
+	m := machine.NewMachine()
+	_, err := m.ParseAndEval(`
+func Mean[T constraints.Integer | constraints.Float](xs []T) float64 {
+	var total T
+	for _, x := range xs {
+		total = total + x
+	}
+	return float64(total) / float64(len(xs))
+}
+
+func Scale[T ~int | ~float64](x T, f float64) T {
+	return T(float64(x) * f)
+}
+`)
+	require.Nil(t, err, err)
+
+	tests := map[string]any{
+		"Mean([]int{1, 2})":     1.5,
+		"Mean([]uint8{255, 0})": 127.5,
+		"Scale(3, 1.5)":         int64(4),
+		"Scale(3.0, 1.5)":       4.5,
+	}
+	for stmt, expected := range tests {
+		res, err := m.ParseAndEval(stmt)
+		require.Nil(t, err, err)
+		require.EqualValues(t, expected, res.Value, stmt)
+	}
+}
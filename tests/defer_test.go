@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDefer tests that deferred calls run in reverse order when the function
+// returns
+func TestDefer(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		order := []int{}
+		f := func() {
+			for i := 0; i < 3; i++ {
+				defer func(n int) {
+					order = append(order, n)
+				}(i)
+			}
+			order = append(order, 10)
+		}
+		f()
+		return order
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, []int{10, 2, 1, 0}, res.NodeToValue().Interface())
+
+	// deferred calls also run when the function fails
+	stmt = `func() {
+		c := make(chan int, 1)
+		f := func() {
+			defer close(c)
+			return undefined
+		}
+		f()
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
+
+// TestPanic tests that panics unwind the script and reach the host
+func TestPanic(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		f := func() {
+			panic("oh no")
+		}
+		f()
+		return 1
+	}()
+	`
+	_, err := m.ParseAndEval(stmt)
+	var p *machine.PanicError
+	require.True(t, errors.As(err, &p), err)
+	require.EqualValues(t, "oh no", p.Value.Value)
+
+	// faults at runtime panic like in go
+	_, err = m.ParseAndEval("[]int{}[0]")
+	require.True(t, errors.As(err, &p), err)
+	require.EqualValues(t, "panic: runtime error: index out of range [0] with length 0", p.Error())
+
+	// other errors are not panics
+	_, err = m.ParseAndEval("undefined")
+	require.NotNil(t, err)
+	require.False(t, errors.As(err, &p), err)
+
+	// panics from functions called by the host
+	fun, err := m.ParseAndEval(`func(n int) { panic(n) }`)
+	require.Nil(t, err, err)
+	arg, _ := machine.ValueToNode(3)
+	_, err = m.CallFunction(fun, []*machine.Node{arg})
+	require.True(t, errors.As(err, &p), err)
+	require.EqualValues(t, 3, p.Value.Value)
+}
+
+// TestRecover tests that deferred calls can recover from panics
+func TestRecover(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		recovered := ""
+		safeDiv := func(a, b int) int {
+			defer func() {
+				recovered = recover()
+			}()
+			if b == 0 {
+				panic("division by zero")
+			}
+			return a / b
+		}
+		return safeDiv(6, 3), safeDiv(1, 0), recovered
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Elems[0].Value)
+	require.EqualValues(t, 0, res.Elems[1].Value)
+	require.EqualValues(t, "division by zero", res.Elems[2].Value)
+
+	// faults at runtime are panics too
+	stmt = `func() {
+		msgs := []string{}
+		try := func(f func()) {
+			defer func() {
+				if r := recover(); r != nil {
+					msgs = append(msgs, r.(error).Error())
+				}
+			}()
+			f()
+		}
+		try(func() { a := []int{1}; i := 1; a[i] = 2 })
+		try(func() { a, b := 1, 0; a /= b })
+		try(func() { var m map[string]int; m["a"] = 1 })
+		try(func() { var p *int; *p = 1 })
+		return msgs
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, []string{
+		"runtime error: index out of range [1] with length 1",
+		"runtime error: integer divide by zero",
+		"assignment to entry in nil map",
+		"runtime error: invalid memory address or nil pointer dereference",
+	}, res.NodeToValue().Interface())
+
+	// recover only works when called directly by a deferred function
+	stmt = `func() {
+		helper := func() {
+			recover()
+		}
+		f := func() {
+			defer func() {
+				helper()
+			}()
+			panic(1)
+		}
+		f()
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	var p *machine.PanicError
+	require.True(t, errors.As(err, &p), err)
+
+	// recover returns nil when there is no panic
+	stmt = `func() {
+		r := 1
+		f := func() {
+			defer func() {
+				_, ok := recover().(any)
+				if !ok {
+					r = 2
+				}
+			}()
+		}
+		f()
+		return r
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Value)
+
+	// recovered values are interfaces that can be compared with nil, and
+	// panicking with nil is still a panic
+	stmt = `func() {
+		try := func(f func()) (res string) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					res = "nil"
+					return
+				}
+				if err, ok := r.(error); ok {
+					res = err.Error()
+					return
+				}
+				if r == 1 {
+					res = "one"
+				}
+			}()
+			f()
+			return "none"
+		}
+		return try(func() { panic(nil) }), try(func() { panic(1) }), try(func() {})
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, "panic called with nil argument", res.Elems[0].Value)
+	require.EqualValues(t, "one", res.Elems[1].Value)
+	require.EqualValues(t, "nil", res.Elems[2].Value)
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+const parsers = `
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrEmpty = errors.New("empty input")
+
+type RangeError struct { Min, Max float64 }
+
+func (e *RangeError) Error() string {
+	return "out of range"
+}
+
+func Parse(xs []float64) (float64, error) {
+	if len(xs) == 0 {
+		return 0, ErrEmpty
+	}
+	total := 0.0
+	for _, x := range xs {
+		if x < 0 || x > 100 {
+			return 0, &RangeError{0, 100}
+		}
+		total = total + x
+	}
+	return total, nil
+}
+
+func Check(xs []float64) error {
+	_, err := Parse(xs)
+	if err != nil {
+		return fmt.Errorf("check %d values: %w", len(xs), err)
+	}
+	return nil
+}
+`
+
+// TestNil tests comparing and assigning nil
+func TestNil(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		var p *int
+		var arr []float64
+		var dict map[string]int
+		var f func()
+		var x any
+		var c chan int
+		isNil := p == nil && arr == nil && dict == nil && f == nil && x == nil && c == nil
+
+		n := 1
+		p = &n
+		arr = []float64{}
+		dict = map[string]int{}
+		f = func() {}
+		x = 1
+		c = make(chan int)
+		notNil := p != nil && arr != nil && dict != nil && f != nil && x != nil && c != nil
+
+		p = nil
+		var q *int = nil
+		return isNil, notNil, p == nil, q == nil
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	for i, elem := range res.Elems {
+		require.EqualValues(t, true, elem.Value, i)
+	}
+
+	failingStmts := []string{
+		`func() { x := nil }()`,
+		`func() { var x int = nil }()`,
+		`func() { return nil == nil }()`,
+		`func() { return 1 == nil }()`,
+		`func(x string) { x = nil }("a")`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+
+	_, err = m.ParseAndEval("nil == nil")
+	require.EqualError(t, err, "invalid operation: nil == nil (operator == not defined on nil)")
+	_, err = m.ParseAndEval("nil != 1")
+	require.EqualError(
+		t,
+		err,
+		"invalid operation: nil != 1 (mismatched types untyped constant and untyped nil)",
+	)
+}
+
+// TestErrors tests making, returning and checking errors in scripts
+func TestErrors(t *testing.T) {
+	m := machine.NewMachine()
+	_, err := m.ParseAndEval(parsers)
+	require.Nil(t, err, err)
+
+	stmt := `func() {
+		var errs []string
+		for _, xs := range [][]float64{{1, 2}, {}, {1, 200}} {
+			if err := Check(xs); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		_, err := Parse([]float64{})
+		return errs, err == ErrEmpty, err.Error()
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(
+		t,
+		[]string{"check 0 values: empty input", "check 2 values: out of range"},
+		res.Elems[0].NodeToValue().Interface(),
+	)
+	require.EqualValues(t, true, res.Elems[1].Value)
+	require.EqualValues(t, "empty input", res.Elems[2].Value)
+
+	failingStmts := []string{
+		`errors.New(1)`,
+		`func() { var err error = 1 }()`,
+		`func() { var err error = RangeError{} }()`,
+		`func() { return ErrEmpty.Message() }()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestErrorsToHost tests that errors returned to the host become go errors
+func TestErrorsToHost(t *testing.T) {
+	m := machine.NewMachine()
+	_, err := m.ParseAndEval(parsers)
+	require.Nil(t, err, err)
+
+	fun, err := m.ParseAndEval("Parse")
+	require.Nil(t, err, err)
+	arg, err := machine.ValueToNode([]float64{1, 2})
+	require.Nil(t, err, err)
+	res, err := m.CallFunction(fun, []*machine.Node{arg})
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3, res.Value)
+
+	arg, err = machine.ValueToNode([]float64{})
+	require.Nil(t, err, err)
+	_, err = m.CallFunction(fun, []*machine.Node{arg})
+	require.EqualError(t, err, "empty input")
+
+	// errors of script types keep their value
+	arg, err = machine.ValueToNode([]float64{-1})
+	require.Nil(t, err, err)
+	_, err = m.CallFunction(fun, []*machine.Node{arg})
+	var scriptErr *machine.ScriptError
+	require.True(t, errors.As(err, &scriptErr), err)
+	require.EqualValues(t, "out of range", scriptErr.Error())
+
+	// wrapped errors can be unwrapped by the host
+	fun, err = m.ParseAndEval("Check")
+	require.Nil(t, err, err)
+	_, err = m.CallFunction(fun, []*machine.Node{arg})
+	require.True(t, errors.As(err, &scriptErr), err)
+
+	// errors passed in by the host can be used as script errors
+	hostErr := errors.New("host")
+	require.Nil(t, m.AddToGlobalContext("hostErr", hostErr))
+	fun, err = m.ParseAndEval(`func() error {
+		return fmt.Errorf("wrapped: %w", hostErr)
+	}`)
+	require.Nil(t, err, err)
+	_, err = m.CallFunction(fun, nil)
+	require.ErrorIs(t, err, hostErr)
+	require.EqualError(t, err, "wrapped: host")
+}
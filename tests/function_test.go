@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/podocarp/goscript/machine"
+	"github.com/podocarp/goscript/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -76,13 +77,144 @@ func TestFunctionArgs(t *testing.T) {
 	require.Nil(t, err, err)
 	require.EqualValues(t, 1, res.Value)
 
-	// extra arguments work
+	// extra arguments are an error
 	stmt = `func(a float64, b float64) {
 		return a
 	}(1, 2, 3, 4, 5)`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+
+	// so are missing ones
+	stmt = `func(a float64, b float64) {
+		return a
+	}(1)`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
+
+// TestFunctionTypes tests that declared parameter and result types are
+// enforced, with ints promoted to floats
+func TestFunctionTypes(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		half := func(x float64) float64 {
+			return x / 2
+		}
+		count := func(a, b) int {
+			return 3
+		}
+		return half(3), count("a", 1.5)
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1.5, res.Elems[0].Value)
+	require.EqualValues(t, types.Float, res.Elems[0].Type.Kind())
+	require.EqualValues(t, 3, res.Elems[1].Value)
+
+	// results are promoted too
+	stmt = `func() {
+		f := func() (float64, string) {
+			return 1, "a"
+		}
+		a, _ := f()
+		return a / 2
+	}()`
 	res, err = m.ParseAndEval(stmt)
 	require.Nil(t, err, err)
-	require.EqualValues(t, 1, res.Value)
+	require.EqualValues(t, 0.5, res.Value)
+
+	stmts := []string{
+		`func(a int) {}(1.5)`,
+		`func(a string) {}(1)`,
+		`func(xs ...int) {}(1, "a")`,
+		`func() int { return "a" }()`,
+		`func() int { return 1, 2 }()`,
+		`func() (int, int) { return 1 }()`,
+		`func() int { a := 1 }()`,
+	}
+	for _, stmt := range stmts {
+		_, err = m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+
+	// arguments from the host are checked
+	fun, err := m.ParseAndEval(`func(xs []float64) float64 { return xs[0] }`)
+	require.Nil(t, err, err)
+	arg, _ := machine.ValueToNode([]string{"a"})
+	_, err = m.CallFunction(fun, []*machine.Node{arg})
+	require.NotNil(t, err)
+	arg, _ = machine.ValueToNode([]float64{2})
+	res, err = m.CallFunction(fun, []*machine.Node{arg})
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Value)
+}
+
+// TestFunctionVariadic tests variadic parameters and spreading arrays into them
+func TestFunctionVariadic(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		sum := func(scale float64, xs ...float64) {
+			total := 0.0
+			for _, x := range xs {
+				total += x
+			}
+			return scale * total, len(xs)
+		}
+		a, n := sum(2)
+		b, _ := sum(2, 1, 2, 3)
+		vals := []float64{4, 5}
+		c, _ := sum(1, vals...)
+		return a, n, b, c
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 0, res.Elems[0].Value)
+	require.EqualValues(t, 0, res.Elems[1].Value)
+	require.EqualValues(t, 12, res.Elems[2].Value)
+	require.EqualValues(t, 9, res.Elems[3].Value)
+
+	// spread arrays are shared with the callee, like in go
+	stmt = `func() {
+		zero := func(xs ...int) {
+			xs[0] = 0
+		}
+		vals := []int{1, 2}
+		zero(vals...)
+		return vals[0]
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 0, res.Value)
+
+	// spreading into builtins
+	stmt = `func() {
+		a := []int{1, 2}
+		b := []int{3, 4}
+		return len(append(a, b...))
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 4, res.Value)
+
+	// variadic functions called from the host
+	fun, err := m.ParseAndEval(`func(xs ...int) { return len(xs) }`)
+	require.Nil(t, err, err)
+	arg, _ := machine.ValueToNode(1)
+	res, err = m.CallFunction(fun, []*machine.Node{arg, arg, arg})
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3, res.Value)
+
+	stmts := []string{
+		`func(a, b int) {}([]int{1, 2}...)`,
+		`func(xs ...int) {}(1, []int{1, 2}...)`,
+		`func(xs ...int, y int) {}(1, 2)`,
+	}
+	for _, stmt := range stmts {
+		_, err = m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
 }
 
 // TestFunctionClosure tests that function closures work as expected
@@ -104,6 +236,63 @@ func TestFunctionClosure(t *testing.T) {
 	assert.EqualValues(t, 20.0, res.Value)
 }
 
+// TestFunctionLexicalScope tests that functions see the variables where they
+// are defined, not where they are called
+func TestFunctionLexicalScope(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		counter := func() {
+			n := 0
+			return func() {
+				n++
+				return n
+			}
+		}
+
+		a := counter()
+		b := counter()
+		a()
+		a()
+		b()
+		return a(), b()
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3, res.Elems[0].Value)
+	require.EqualValues(t, 2, res.Elems[1].Value)
+
+	// variables of the caller are not visible
+	stmt = `func() {
+		get := func() {
+			return x
+		}
+		call := func() {
+			x := 1
+			return get()
+		}
+		return call()
+	}()`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+
+	// closures can outlive the script that made them
+	fun, err := m.ParseAndEval(`func() {
+		total := 0
+		return func(n) {
+			total += n
+			return total
+		}
+	}()`)
+	require.Nil(t, err, err)
+	arg, _ := machine.ValueToNode(5)
+	_, err = m.CallFunction(fun, []*machine.Node{arg})
+	require.Nil(t, err, err)
+	res, err = m.CallFunction(fun, []*machine.Node{arg})
+	require.Nil(t, err, err)
+	require.EqualValues(t, 10, res.Value)
+}
+
 // TestFunctionReturn tests that return statements work as expected
 func TestFunctionReturn(t *testing.T) {
 	m := machine.NewMachine()
@@ -122,6 +311,16 @@ func TestFunctionReturn(t *testing.T) {
 	res, err := m.ParseAndEval(stmt)
 	assert.Nil(t, err, err)
 	assert.EqualValues(t, 6.0, res.Value)
+
+	// results of calls with no value cannot be returned
+	failingStmts := []string{
+		`func() { f := func() {}; return f() }()`,
+		`func() { f := func() {}; return 1, f() }()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		assert.NotNil(t, err, stmt)
+	}
 }
 
 // TestFunctionMultiReturn tests that multi return statements work as expected
@@ -178,3 +377,76 @@ func TestRecursionBasic(t *testing.T) {
 	require.Nil(t, err, err)
 	require.EqualValues(t, 8, res.Value)
 }
+
+// TestFunctionNamedResults tests named results and bare returns
+func TestFunctionNamedResults(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		stats := func(xs []float64) (sum float64, n int) {
+			for _, x := range xs {
+				sum += x
+				n++
+			}
+			return
+		}
+		sum, n := stats([]float64{1, 2, 3.5})
+		return sum, n
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 6.5, res.Elems[0].Value)
+	require.EqualValues(t, 3, res.Elems[1].Value)
+
+	// named results start as zero values, and a bare return stops the
+	// function
+	stmt = `func() {
+		f := func(stop bool) (s string, x float64) {
+			if stop {
+				return
+			}
+			return "a", 1
+		}
+		s, x := f(true)
+		return s, x
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, "", res.Elems[0].Value)
+	require.EqualValues(t, 0, res.Elems[1].Value)
+
+	// deferred calls see and can change the named results
+	stmt = `func() {
+		seen := 0
+		f := func() (n int) {
+			defer func() {
+				seen = n
+				n *= 2
+			}()
+			return 21
+		}
+		return f(), seen
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 42, res.Elems[0].Value)
+	require.EqualValues(t, 21, res.Elems[1].Value)
+
+	// recovering from a panic returns the named results
+	stmt = `func() {
+		parse := func() (n int, err string) {
+			defer func() {
+				if _, ok := recover().(string); ok {
+					err = "failed"
+				}
+			}()
+			n = 5
+			panic("bad input")
+		}
+		return parse()
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 5, res.Elems[0].Value)
+	require.EqualValues(t, "failed", res.Elems[1].Value)
+}
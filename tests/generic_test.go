@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+const generics = `
+type Number interface {
+	constraints.Integer | constraints.Float
+}
+
+func Sum[T Number](xs ...T) T {
+	var total T
+	for _, x := range xs {
+		total = total + x
+	}
+	return total
+}
+
+func Mean[T int | float64](xs []T) T {
+	return Sum(xs...) / len(xs)
+}
+
+func Map[S, T any](xs []S, f func(S) T) []T {
+	res := []T{}
+	for _, x := range xs {
+		res = append(res, f(x))
+	}
+	return res
+}
+
+func Add[T ~int | ~float64](a, b T) T {
+	return a + b
+}
+
+func Empty[T any]() []T {
+	return []T{}
+}
+
+func Index[T comparable](xs []T, x T) int {
+	for i, v := range xs {
+		if v == x {
+			return i
+		}
+	}
+	return -1
+}
+
+type Stack[T any] struct { items []T }
+
+func (s *Stack[T]) Push(v T) {
+	s.items = append(s.items, v)
+}
+
+func (s *Stack[T]) Pop() T {
+	v := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return v
+}
+
+func (s Stack[_]) Len() int {
+	return len(s.items)
+}
+`
+
+// TestGenericFunctions tests calling generic functions with explicit and
+// inferred type arguments
+func TestGenericFunctions(t *testing.T) {
+	m := machine.NewMachine()
+	_, err := m.ParseAndEval(generics)
+	require.Nil(t, err, err)
+
+	tests := map[string]any{
+		"Mean([]float64{1, 2, 4.5})":                2.5,
+		"Mean([]int{1, 2, 4})":                      int64(2),
+		"Mean[float64]([]float64{1, 2})":            1.5,
+		"Sum[int8](100, 27)":                        int64(127),
+		"Sum(1, 2, 3)":                              int64(6),
+		"Sum(1.5, 2)":                               3.5,
+		"Sum[uint]()":                               uint64(0),
+		`Index([]string{"a", "b"}, "b")`:            int64(1),
+		"Index([]float64{1, 2, 3}, 3)":              int64(2),
+		"len(Map[int, int]([]int{1, 2}, Sum[int]))": int64(2),
+	}
+	for stmt, expected := range tests {
+		res, err := m.ParseAndEval(stmt)
+		require.Nil(t, err, err)
+		require.EqualValues(t, expected, res.Value, stmt)
+	}
+
+	// the type arguments decide the types inside the function
+	res, err := m.ParseAndEval(`func() {
+		type Score int
+		var s Score = 3
+		strs := Map[int, string]([]int{1, 2}, func(i int) string { return "x" })
+		// type arguments are inferred from the types of function literals
+		lens := Map([]string{"a", "bc"}, func(s string) int { return len(s) })
+		return Sum[int32](1, 2), Add(s, 2), strs, lens
+	}()`)
+	require.Nil(t, err, err)
+	require.EqualValues(t, "int32", res.Elems[0].Type.String())
+	require.EqualValues(t, 5, res.Elems[1].Value)
+	require.EqualValues(t, "Score", res.Elems[1].Type.String())
+	require.EqualValues(t, "[]string", res.Elems[2].Type.String())
+	require.EqualValues(t, []int{1, 2}, res.Elems[3].NodeToValue().Interface())
+}
+
+// TestGenericTypes tests instantiating generic types and calling their methods
+func TestGenericTypes(t *testing.T) {
+	m := machine.NewMachine()
+	_, err := m.ParseAndEval(generics)
+	require.Nil(t, err, err)
+
+	res, err := m.ParseAndEval(`func() {
+		s := Stack[string]{}
+		s.Push("a")
+		s.Push("b")
+		top := s.Pop()
+		p := &Stack[float64]{}
+		p.Push(1)
+		return top, s.Len(), p.Pop()
+	}()`)
+	require.Nil(t, err, err)
+	require.EqualValues(t, "b", res.Elems[0].Value)
+	require.EqualValues(t, 1, res.Elems[1].Value)
+	require.EqualValues(t, 1.0, res.Elems[2].Value)
+
+	// the same type arguments give the same type
+	res, err = m.ParseAndEval(`func() {
+		type Pair[K comparable, V any] struct { Key K; Val V }
+		a := Pair[string, int]{"a", 1}
+		var b Pair[string, int] = a
+		var x any = b
+		_, isPair := x.(Pair[string, int])
+		return b.Key, isPair
+	}()`)
+	require.Nil(t, err, err)
+	require.EqualValues(t, "a", res.Elems[0].Value)
+	require.EqualValues(t, true, res.Elems[1].Value)
+}
+
+// TestGenericsInvalid tests instantiations that do not satisfy their
+// constraints or cannot be inferred
+func TestGenericsInvalid(t *testing.T) {
+	m := machine.NewMachine()
+	_, err := m.ParseAndEval(generics)
+	require.Nil(t, err, err)
+
+	failingStmts := []string{
+		// constraints not satisfied
+		`Sum("a", "b")`,
+		`Mean[string]`,
+		`Index([][]int{}, []int{})`,
+		`Stack[int, int]{}`,
+		// type arguments that do not match or cannot be inferred
+		`Sum(1.5, len("a"))`,
+		`Sum[int, int](1)`,
+		`Empty()`,
+		`Map([]int{1}, Sum[int])`,
+		`Map([]int{1}, func(s string) int { return 1 })`,
+		// untyped parameters and results do not say what T is
+		`Map([]int{1}, func(x) { return x })`,
+		`Add("a", "b")`,
+		// generic types must be instantiated
+		`Stack{}`,
+		`func() { var s Stack }()`,
+		// constraints are not types of values
+		`func() { var n Number = 1 }()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestChannelBasic tests sending and receiving on channels
+func TestChannelBasic(t *testing.T) {
+	m := machine.NewMachine()
+
+	// buffered channels do not need another goroutine
+	stmt := `func() {
+		c := make(chan int, 2)
+		c <- 1
+		c <- 2
+		n := len(c)
+		a := <-c
+		b := <-c
+		return a, b, n
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Elems[0].Value)
+	require.EqualValues(t, 2, res.Elems[1].Value)
+	require.EqualValues(t, 2, res.Elems[2].Value)
+
+	// closed channels give the zero value
+	stmt = `func() {
+		c := make(chan float64, 1)
+		c <- 1
+		close(c)
+		a, ok1 := <-c
+		b, ok2 := <-c
+		return a, ok1, b, ok2
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Elems[0].Value)
+	require.EqualValues(t, true, res.Elems[1].Value)
+	require.EqualValues(t, 0, res.Elems[2].Value)
+	require.EqualValues(t, false, res.Elems[3].Value)
+
+	stmt = `func() {
+		c := make(chan int)
+		close(c)
+		c <- 1
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
+
+// TestGoroutines tests that goroutines can communicate through channels
+func TestGoroutines(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		produce := func(c chan int, n int) {
+			for i := 1; i <= n; i++ {
+				c <- i
+			}
+			close(c)
+		}
+
+		c := make(chan int)
+		go produce(c, 10)
+		sum := 0
+		for v := range c {
+			sum += v
+		}
+		return sum
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 55, res.Value)
+
+	// fan out to workers and fan back in
+	stmt = `func() {
+		square := func(in chan float64, out chan float64) {
+			for v := range in {
+				out <- v * v
+			}
+		}
+
+		in := make(chan float64)
+		out := make(chan float64, 4)
+		for i := 0; i < 3; i++ {
+			go square(in, out)
+		}
+
+		vals := []float64{1, 2, 3, 4, 5, 6}
+		go func() {
+			for _, v := range vals {
+				in <- v
+			}
+			close(in)
+		}()
+
+		sum := 0.0
+		for i := 0; i < len(vals); i++ {
+			sum += <-out
+		}
+		return sum
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 91, res.Value)
+}
+
+// TestGoroutineDeadlock tests that deadlocks are reported as errors and do not
+// leave goroutines running
+func TestGoroutineDeadlock(t *testing.T) {
+	m := machine.NewMachine()
+	before := runtime.NumGoroutine()
+
+	stmt := `func() {
+		c := make(chan int)
+		return <-c
+	}()
+	`
+	_, err := m.ParseAndEval(stmt)
+	require.True(t, errors.Is(err, machine.ErrDeadlock), err)
+
+	stmt = `func() {
+		c := make(chan int)
+		d := make(chan int)
+		go func() {
+			d <- <-c
+		}()
+		return <-d
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.True(t, errors.Is(err, machine.ErrDeadlock), err)
+
+	// goroutines still blocked when the script ends are cleaned up
+	stmt = `func() {
+		c := make(chan int)
+		for i := 0; i < 5; i++ {
+			go func() {
+				c <- 1
+			}()
+		}
+		return <-c
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Value)
+
+	// host goroutines may take a moment to exit after being stopped
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, before, runtime.NumGoroutine())
+}
+
+// TestGoroutineError tests that an error in a goroutine stops the script
+func TestGoroutineError(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		c := make(chan int)
+		go func() {
+			c <- undefined
+		}()
+		return <-c
+	}()
+	`
+	_, err := m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+	require.False(t, errors.Is(err, machine.ErrDeadlock), err)
+}
+
+// TestGoroutinePanic tests that a panic in a goroutine stops the script, and
+// cannot be recovered by another goroutine
+func TestGoroutinePanic(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() (res int) {
+		defer func() {
+			if recover() != nil {
+				res = 9
+			}
+		}()
+		c := make(chan int)
+		go func() {
+			panic("boom")
+		}()
+		return <-c
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+	require.Nil(t, res)
+	require.Contains(t, err.Error(), "boom")
+	var p *machine.PanicError
+	require.False(t, errors.As(err, &p), err)
+}
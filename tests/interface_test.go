@@ -0,0 +1,247 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+const aggregators = `
+type Agg interface {
+	Add(v float64)
+	Result() float64
+}
+
+type Mean struct { sum float64; n int }
+
+func (m *Mean) Add(v float64) {
+	m.sum = m.sum + v
+	m.n = m.n + 1
+}
+
+func (m *Mean) Result() float64 {
+	return m.sum / m.n
+}
+
+type Max struct { max float64 }
+
+func (m *Max) Add(v float64) {
+	if v > m.max {
+		m.max = v
+	}
+}
+
+func (m *Max) Result() float64 {
+	return m.max
+}
+
+type Count struct { n float64 }
+
+func (c Count) Result() float64 {
+	return c.n
+}
+
+func Aggregate(agg Agg, vals []float64) float64 {
+	for _, v := range vals {
+		agg.Add(v)
+	}
+	return agg.Result()
+}
+`
+
+// TestInterfaces tests storing values in interfaces and calling their methods
+func TestInterfaces(t *testing.T) {
+	m := machine.NewMachine()
+	_, err := m.ParseAndEval(aggregators)
+	require.Nil(t, err, err)
+
+	stmt := `func() {
+		vals := []float64{1, 5, 3}
+		aggs := map[string]Agg{"mean": &Mean{}, "max": &Max{}}
+		return Aggregate(aggs["mean"], vals), Aggregate(aggs["max"], vals)
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3, res.Elems[0].Value)
+	require.EqualValues(t, 5, res.Elems[1].Value)
+
+	// implementations can be swapped at runtime
+	stmt = `func(name string) {
+		var agg Agg = &Mean{}
+		if name == "max" {
+			agg = &Max{}
+		}
+		agg.Add(2)
+		agg.Add(4)
+		return agg.Result()
+	}`
+	fun, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	for name, expected := range map[string]float64{"mean": 3, "max": 4} {
+		arg, err := machine.ValueToNode(name)
+		require.Nil(t, err, err)
+		res, err = m.CallFunction(fun, []*machine.Node{arg})
+		require.Nil(t, err, err)
+		require.EqualValues(t, expected, res.Value)
+	}
+
+	// interfaces can embed other interfaces, and type assertions and
+	// switches check method sets
+	stmt = `func() {
+		type Resulter interface { Result() float64 }
+		type Resetter interface {
+			Resulter
+			Reset()
+		}
+
+		var r Resulter = Count{3}
+		_, isAgg := r.(Agg)
+		_, isResetter := r.(Resetter)
+		kind := ""
+		var x any = &Max{}
+		switch v := x.(type) {
+		case Resetter:
+			kind = "resetter"
+		case Agg:
+			kind = "agg"
+			v.Add(10)
+		}
+		return r.Result(), isAgg, isResetter, kind
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3, res.Elems[0].Value)
+	require.EqualValues(t, false, res.Elems[1].Value)
+	require.EqualValues(t, false, res.Elems[2].Value)
+	require.EqualValues(t, "agg", res.Elems[3].Value)
+}
+
+// TestInterfacesInvalid tests that values without the right methods cannot be
+// used as interfaces
+func TestInterfacesInvalid(t *testing.T) {
+	m := machine.NewMachine()
+	_, err := m.ParseAndEval(aggregators)
+	require.Nil(t, err, err)
+
+	failingStmts := []string{
+		// missing methods
+		`func() { var a Agg = Count{1} }()`,
+		`func() { var a Agg = 1 }()`,
+		`Aggregate(Count{1}, []float64{})`,
+		// pointer receivers are only in the method set of pointers
+		`func() { var a Agg = Mean{} }()`,
+		// calling methods on nil interfaces
+		`func() {
+			type Holder struct { A Agg }
+			Holder{}.A.Add(1)
+		}()`,
+		`func() {
+			type Bad interface { M(); M() }
+		}()`,
+		// constraints are not types of values
+		`func() {
+			type Number interface { int | float64 }
+			var x Number = 1
+		}()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+
+	// methods need the same number of parameters and results as the ones
+	// of the interface
+	_, err = m.ParseAndEval(`
+type Sink struct{}
+
+func (Sink) Add(v float64, n int) {}
+
+func (Sink) Result() float64 { return 0 }
+
+type Lossy struct{}
+
+func (Lossy) Add(v float64) {}
+
+func (Lossy) Result() {}
+`)
+	require.Nil(t, err, err)
+	failingStmts = []string{
+		`func() { var a Agg = Sink{} }()`,
+		`func() { var a Agg = Lossy{} }()`,
+		`func() { var x any = Sink{}; return x.(Agg) }()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+	res, err := m.ParseAndEval(`func() { var x any = Sink{}; _, ok := x.(Agg); return ok }()`)
+	require.Nil(t, err, err)
+	require.EqualValues(t, false, res.Value)
+}
+
+// TestInterfacesDynamicType tests that values stored in interfaces keep their
+// type, so that interfaces holding nil pointers are not nil and values of
+// different types are not equal
+func TestInterfacesDynamicType(t *testing.T) {
+	m := machine.NewMachine()
+	_, err := m.ParseAndEval(aggregators)
+	require.Nil(t, err, err)
+
+	stmt := `func() {
+		var mean *Mean
+		var a Agg = mean
+		var x, y any = 1, 1.0
+		return a == nil, a != nil, mean == nil, x == y, x == 1, x == int8(1)
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, false, res.Elems[0].Value)
+	require.EqualValues(t, true, res.Elems[1].Value)
+	require.EqualValues(t, true, res.Elems[2].Value)
+	require.EqualValues(t, false, res.Elems[3].Value)
+	require.EqualValues(t, true, res.Elems[4].Value)
+	require.EqualValues(t, false, res.Elems[5].Value)
+
+	// nil pointers returned as errors are not nil errors, but the pointers
+	// asserted out of them are nil
+	_, err = m.ParseAndEval(`
+type NotFound struct{}
+
+func (e *NotFound) Error() string { return "not found" }
+
+func find() error {
+	var e *NotFound
+	return e
+}
+`)
+	require.Nil(t, err, err)
+	stmt = `func() {
+		err := find()
+		p := err.(*NotFound)
+		var q *NotFound
+		switch v := err.(type) {
+		case *NotFound:
+			q = v
+		}
+		return err != nil, p == nil, q == nil
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, true, res.Elems[0].Value)
+	require.EqualValues(t, true, res.Elems[1].Value)
+	require.EqualValues(t, true, res.Elems[2].Value)
+
+	// variables holding interfaces can be set to values of other types
+	stmt = `func() {
+		var x any = 1
+		x = "a"
+		return x == "a"
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, true, res.Value)
+}
@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/podocarp/goscript/types"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMapDefine tests that map literals can be defined and indexed
+func TestMapDefine(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		c := map[string]float64{"cpu": 1, "mem": 2.5}
+		return c["cpu"] + c["mem"]
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3.5, res.Value)
+
+	// elided types in nested literals
+	stmt = `func() {
+		c := map[string][]int{"a": {1, 2}, "b": {3}}
+		return c["a"][1] + c["b"][0]
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 5, res.Value)
+
+	// missing keys give the zero value
+	stmt = `func() {
+		c := map[string]int{}
+		return c["nothing"]
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 0, res.Value)
+
+	// type mismatch is not ok
+	stmt = `func() {
+		c := map[string]int{1: 1}
+		return c
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+
+	// duplicate keys are not ok
+	stmt = `func() {
+		c := map[string]int{"a": 1, "a": 2}
+		return c
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
+
+// TestMapMake tests that make(), assignment, delete() and len() work on maps
+func TestMapMake(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		c := make(map[string]int)
+		c["a"] = 1
+		c["b"] = 2
+		c["a"] = 10
+		delete(c, "b")
+		delete(c, "not there")
+		return len(c), c["a"]
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Elems[0].Value)
+	require.EqualValues(t, 10, res.Elems[1].Value)
+
+	stmt = `func() {
+		return make(map[string][]int)
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	expectedType := types.MapOf(types.StringType, types.ArrayOf(types.IntType))
+	require.True(t, res.Type.Equal(expectedType), res.Type)
+
+	// maps are references
+	stmt = `func() {
+		c := make(map[int]float64, 10)
+		d := c
+		d[1] = 2
+		return c[1]
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2.0, res.Value)
+}
+
+// TestMapCommaOk tests the v, ok := m[k] form
+func TestMapCommaOk(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		c := map[string]int{"a": 5}
+		v, ok := c["a"]
+		w, ok2 := c["b"]
+		return v, ok, w, ok2
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 5, res.Elems[0].Value)
+	require.EqualValues(t, true, res.Elems[1].Value)
+	require.EqualValues(t, 0, res.Elems[2].Value)
+	require.EqualValues(t, false, res.Elems[3].Value)
+
+	// only map indexes give a second value
+	stmts := []string{
+		`func() { a, b := "abc"[0] }()`,
+		`func() { arr := []int{1}; a, b := arr[0] }()`,
+		`func() { var a, b int; a, b = 5[0] }()`,
+	}
+	for _, stmt := range stmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+	_, err = m.ParseAndEval(stmts[0])
+	require.ErrorContains(t, err, "assignment mismatch")
+}
+
+// TestMapInterfaceKeys tests that keys stored in interfaces are only the same
+// when their types are
+func TestMapInterfaceKeys(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		c := map[any]int{1: 1, int8(1): 2}
+		c[int64(1)] = 3
+		c[1]++
+		delete(c, int8(1))
+		return len(c), c[1], c[int8(1)], c[int64(1)]
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Elems[0].Value)
+	require.EqualValues(t, 2, res.Elems[1].Value)
+	require.EqualValues(t, 0, res.Elems[2].Value)
+	require.EqualValues(t, 3, res.Elems[3].Value)
+
+	_, err = m.ParseAndEval(`func() { c := map[any]int{1: 1, 1: 2} }()`)
+	require.NotNil(t, err)
+
+	err = m.AddToGlobalContext("counts", map[any]int{1: 1, "1": 2})
+	require.Nil(t, err, err)
+	res, err = m.ParseAndEval(`func() { return counts[1], counts["1"], counts[1.0] }()`)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Elems[0].Value)
+	require.EqualValues(t, 2, res.Elems[1].Value)
+	require.EqualValues(t, 0, res.Elems[2].Value)
+}
+
+// TestMapRange tests ranging over maps
+func TestMapRange(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		c := map[string]int{"a": 1, "b": 2, "c": 3}
+		keys := ""
+		sum := 0
+		for k, v := range c {
+			keys = k
+			sum += v
+		}
+		return sum, len(keys)
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 6, res.Elems[0].Value)
+	require.EqualValues(t, 1, res.Elems[1].Value)
+}
+
+// TestMapToNode tests that go maps can be converted to and from nodes
+func TestMapToNode(t *testing.T) {
+	labels := map[string]float64{"cpu": 0.5, "mem": 0.25}
+	m := machine.NewMachine()
+	err := m.AddToGlobalContext("labels", labels)
+	require.Nil(t, err, err)
+
+	stmt := `func() {
+		res := map[string]float64{}
+		for k, v := range labels {
+			res[k] = v * 2
+		}
+		return res
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.Equal(t, types.Map, res.Type.Kind())
+	require.EqualValues(
+		t,
+		map[string]float64{"cpu": 1, "mem": 0.5},
+		res.NodeToValue().Interface(),
+	)
+
+	node, err := machine.ValueToNode(map[string]bool{"up": true})
+	require.Nil(t, err, err)
+	require.EqualValues(t, map[string]bool{"up": true}, node.NodeToValue().Interface())
+}
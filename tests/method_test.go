@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMethods tests methods with value and pointer receivers
+func TestMethods(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `
+	type Point struct { X, Y float64 }
+
+	func (p Point) Norm() float64 {
+		return p.X*p.X + p.Y*p.Y
+	}
+
+	func (p *Point) Scale(f float64) {
+		p.X = p.X * f
+		p.Y = p.Y * f
+	}
+
+	// value receivers get a copy
+	func (p Point) Reset() {
+		p.X = 0
+	}
+	`
+	_, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+
+	stmt = `func() {
+		p := Point{1, 2}
+		before := p.Norm()
+		p.Scale(2)
+		p.Reset()
+		q := &p
+		q.Scale(0.5)
+		return before, p.Norm(), q.Norm()
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 5, res.Elems[0].Value)
+	require.EqualValues(t, 5, res.Elems[1].Value)
+	require.EqualValues(t, 5, res.Elems[2].Value)
+
+	// methods can be used as values, bound to their receiver
+	stmt = `func() {
+		p := Point{3, 4}
+		norm := p.Norm
+		scale := p.Scale
+		scale(2)
+		return norm(), p.Norm()
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 25, res.Elems[0].Value)
+	require.EqualValues(t, 100, res.Elems[1].Value)
+}
+
+// TestMethodsWindow tests keeping state in a type with methods
+func TestMethodsWindow(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `
+	type Window struct {
+		vals []float64
+		size int
+	}
+
+	func NewWindow(size int) *Window {
+		return &Window{vals: []float64{}, size: size}
+	}
+
+	func (w *Window) Push(v float64) {
+		w.vals = append(w.vals, v)
+		if len(w.vals) > w.size {
+			w.vals = w.vals[1:]
+		}
+	}
+
+	func (w *Window) Mean() float64 {
+		sum := 0.0
+		for _, v := range w.vals {
+			sum += v
+		}
+		return sum / len(w.vals)
+	}
+	`
+	_, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+
+	stmt = `func() {
+		w := NewWindow(3)
+		means := []float64{}
+		for _, v := range []float64{1, 2, 3, 4, 5} {
+			w.Push(v)
+			means = append(means, w.Mean())
+		}
+		return means
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, []float64{1, 1.5, 2, 3, 4}, res.NodeToValue().Interface())
+}
+
+// TestMethodsNamedFloat tests methods on named types that are not structs,
+// which keep their type through arithmetic and conversions
+func TestMethodsNamedFloat(t *testing.T) {
+	m := machine.NewMachine()
+
+	_, err := m.ParseAndEval(`
+	type Celsius float64
+
+	func (c Celsius) F() float64 {
+		return c*9/5 + 32
+	}
+	`)
+	require.Nil(t, err, err)
+
+	stmt := `func() {
+		var c Celsius = 100
+		temps := []Celsius{1.5}
+		d := -c
+		return c.F(), (c + 1).F(), temps[0].F(), d.F(), (2 * c).F()
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.InDelta(t, 212, res.Elems[0].Value, 1e-9)
+	require.InDelta(t, 213.8, res.Elems[1].Value, 1e-9)
+	require.InDelta(t, 34.7, res.Elems[2].Value, 1e-9)
+	require.InDelta(t, -148, res.Elems[3].Value, 1e-9)
+	require.InDelta(t, 392, res.Elems[4].Value, 1e-9)
+}
+
+// TestMethodsInvalid tests that bad method declarations and calls are errors
+func TestMethodsInvalid(t *testing.T) {
+	failingStmts := []string{
+		// unknown methods
+		`type Point struct { X int }
+		func (p Point) Get() int { return p.X }
+		var x = Point{}.Set()`,
+		// methods on unnamed types
+		`func (p []int) Len() int { return len(p) }`,
+		// duplicate methods
+		`type Point struct { X int }
+		func (p Point) Get() int { return p.X }
+		func (p *Point) Get() int { return p.X }`,
+		// fields and methods with the same name
+		`type Point struct { X int }
+		func (p Point) X() int { return 1 }`,
+		// pointer methods need an addressable receiver
+		`type Point struct { X int }
+		func (p *Point) Set() { p.X = 1 }
+		var x = Point{}.Set()`,
+		// still a syntax error
+		`type Point struct { X int`,
+		`import "os"`,
+	}
+	for _, stmt := range failingStmts {
+		m := machine.NewMachine()
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
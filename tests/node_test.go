@@ -106,13 +106,15 @@ func TestNodeToValue(t *testing.T) {
 func TestValueToNodeInverse(t *testing.T) {
 	tests := []any{
 		int64(100), "100", 100.0, uint64(100),
+		100, int8(-100), int16(100), int32(-100),
+		uint(100), uint8(100), uint16(100), uint32(100),
+		[]byte("100"), map[rune]int8{'a': -1},
 	}
 
 	for _, test := range tests {
 		node, err := machine.ValueToNode(test)
 		require.Nil(t, err)
 		val := node.NodeToValue()
-		expected := reflect.ValueOf(test)
-		require.True(t, expected.Equal(val))
+		require.Equal(t, test, val.Interface())
 	}
 }
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/podocarp/goscript/types"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPointerBasic tests taking the address of variables and dereferencing
+func TestPointerBasic(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		a := 1
+		p := &a
+		*p = 10
+		b := *p + 1
+		return a, b
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 10, res.Elems[0].Value)
+	require.EqualValues(t, 11, res.Elems[1].Value)
+
+	// pointers to array elements and struct fields
+	stmt = `func() {
+		type Point struct { T, V float64 }
+		arr := []float64{1, 2, 3}
+		p := &arr[1]
+		*p = 20
+
+		pt := Point{1, 2}
+		q := &pt.V
+		*q = 5
+		return arr[1], pt.V
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 20, res.Elems[0].Value)
+	require.EqualValues(t, 5, res.Elems[1].Value)
+
+	// nil pointers cannot be dereferenced
+	stmt = `func() {
+		p := new(*int)
+		return **p
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
+
+// TestPointerNew tests new() and &T{}
+func TestPointerNew(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		p := new(float64)
+		*p = *p + 1.5
+		return p
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.True(t, res.Type.Equal(types.PointerOf(types.FloatType)), res.Type)
+	require.EqualValues(t, 1.5, res.NodeToValue().Elem().Float())
+
+	stmt = `func() {
+		type Point struct { T, V float64 }
+		p := &Point{T: 1}
+		p.V = 2
+		q := p
+		q.T = 3
+		return p.T + p.V
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 5, res.Value)
+}
+
+// TestPointerParams tests that functions can mutate state through pointer
+// parameters
+func TestPointerParams(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		type Counter struct { N int }
+		incr := func(c *Counter, by *int) {
+			c.N = c.N + *by
+			*by = 0
+		}
+
+		c := Counter{}
+		by := 5
+		incr(&c, &by)
+		incr(&c, &by)
+		return c.N, by
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 5, res.Elems[0].Value)
+	require.EqualValues(t, 0, res.Elems[1].Value)
+
+	// each loop iteration declares a new variable
+	stmt = `func() {
+		ptrs := make([]*int, 0)
+		for i := 0; i < 3; i++ {
+			v := i
+			ptrs = append(ptrs, &v)
+		}
+		return *ptrs[0] + *ptrs[1] + *ptrs[2]
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3, res.Value)
+}
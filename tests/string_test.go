@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStringConcat tests string concatenation
+func TestStringConcat(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		s := "foo" + "bar"
+		s += "baz"
+		return s
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, "foobarbaz", res.Value)
+
+	failingStmts := []string{
+		`"a" + 1`,
+		`1 + "a"`,
+		`"a" - "b"`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestStringCompare tests comparison operators on strings
+func TestStringCompare(t *testing.T) {
+	m := machine.NewMachine()
+
+	tests := map[string]bool{
+		`"a" == "a"`:    true,
+		`"a" != "a"`:    false,
+		`"a" < "b"`:     true,
+		`"ab" < "a"`:    false,
+		`"b" > "a"`:     true,
+		`"a" >= "a"`:    true,
+		`"a" <= "b"`:    true,
+		`"abc" <= "ab"`: false,
+	}
+	for stmt, expected := range tests {
+		res, err := m.ParseAndEval(stmt)
+		require.Nil(t, err, err)
+		require.EqualValues(t, expected, res.Value, stmt)
+	}
+}
+
+// TestStringIndex tests indexing and slicing strings
+func TestStringIndex(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		s := "hello"
+		return s[0], s[4], s[1:3], s[:2], s[3:], s[:]
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 'h', res.Elems[0].Value)
+	require.EqualValues(t, 'o', res.Elems[1].Value)
+	require.EqualValues(t, "el", res.Elems[2].Value)
+	require.EqualValues(t, "he", res.Elems[3].Value)
+	require.EqualValues(t, "lo", res.Elems[4].Value)
+	require.EqualValues(t, "hello", res.Elems[5].Value)
+
+	// indexing gives bytes, not runes
+	res, err = m.ParseAndEval(`"héllo"[1]`)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 0xc3, res.Value)
+
+	res, err = m.ParseAndEval(`"abc"[1] == 'b'`)
+	require.Nil(t, err, err)
+	require.EqualValues(t, true, res.Value)
+
+	failingStmts := []string{
+		`"abc"[3]`,
+		`"abc"[-1]`,
+		`"abc"[2:1]`,
+		`"abc"[:4]`,
+		`"abc"[0:1:2]`,
+		`func() { s := "abc"; s[0] = 'x' }()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestStringRange tests ranging over the runes of a string
+func TestStringRange(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		offsets := []int{}
+		runes := []rune{}
+		for i, r := range "aé😀b" {
+			offsets = append(offsets, i)
+			runes = append(runes, r)
+		}
+		return offsets, runes
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	val := res.Elems[0].NodeToValue().Interface()
+	require.EqualValues(t, []int{0, 1, 3, 7}, val)
+	val = res.Elems[1].NodeToValue().Interface()
+	require.EqualValues(t, []rune{'a', 'é', '😀', 'b'}, val)
+
+	stmt = `func() {
+		n := 0
+		for range "héllo" {
+			n++
+		}
+		return n
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 5, res.Value)
+}
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/podocarp/goscript/types"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStructDefine tests that struct types can be declared and used in
+// literals
+func TestStructDefine(t *testing.T) {
+	m := machine.NewMachine()
+
+	// keyed literals
+	stmt := `func() {
+		type Point struct { T, V float64 }
+		p := Point{T: 1, V: 2.5}
+		return p.T + p.V
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3.5, res.Value)
+
+	// positional literals
+	stmt = `func() {
+		type Point struct { T, V float64 }
+		p := Point{1, 2}
+		return p.V
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Value)
+
+	// missing fields are zero
+	stmt = `func() {
+		type Point struct {
+			Name string
+			T float64
+			Tags []string
+		}
+		p := Point{T: 1}
+		return p.Name, p.T, len(p.Tags)
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, "", res.Elems[0].Value)
+	require.EqualValues(t, 1, res.Elems[1].Value)
+	require.EqualValues(t, 0, res.Elems[2].Value)
+
+	// arrays of structs with elided types
+	stmt = `func() {
+		type Point struct { T, V float64 }
+		points := []Point{ {1, 2}, {T: 3, V: 4} }
+		sum := 0
+		for _, p := range points {
+			sum += p.V
+		}
+		return sum
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 6, res.Value)
+}
+
+// TestStructErrors tests that malformed struct literals are rejected
+func TestStructErrors(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmts := []string{
+		// unknown field
+		`func() {
+			type Point struct { T, V float64 }
+			return Point{X: 1}
+		}()`,
+		// too few values
+		`func() {
+			type Point struct { T, V float64 }
+			return Point{1}
+		}()`,
+		// mixed keyed and positional
+		`func() {
+			type Point struct { T, V float64 }
+			return Point{T: 1, 2}
+		}()`,
+		// wrong type
+		`func() {
+			type Point struct { T, V float64 }
+			return Point{"1", 2}
+		}()`,
+		// unknown field selector
+		`func() {
+			type Point struct { T, V float64 }
+			p := Point{}
+			return p.X
+		}()`,
+	}
+	for _, stmt := range stmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+}
+
+// TestStructAssign tests that struct fields can be written to, and that structs
+// are copied on assignment
+func TestStructAssign(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		type Point struct { T, V float64 }
+		p := Point{1, 2}
+		q := p
+		p.V = 10
+		return p.V, q.V
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 10, res.Elems[0].Value)
+	require.EqualValues(t, 2, res.Elems[1].Value)
+
+	// nested fields and fields in arrays
+	stmt = `func() {
+		type Point struct { T, V float64 }
+		type Series struct {
+			Name string
+			Last Point
+			Points []Point
+		}
+		s := Series{Name: "cpu", Points: []Point{ {1, 2}, {3, 4} }}
+		s.Last.V = 5
+		s.Points[1].V = 6
+		return s.Last.V + s.Points[1].V + s.Points[0].V
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 13, res.Value)
+}
+
+// TestStructToValue tests that structs can be converted to and from go values
+func TestStructToValue(t *testing.T) {
+	type point struct {
+		T float64
+		v float64
+	}
+
+	node, err := machine.ValueToNode(point{T: 1, v: 2})
+	require.Nil(t, err, err)
+	require.Equal(t, types.Struct, node.Type.Kind())
+
+	m := machine.NewMachine()
+	err = m.AddToGlobalContext("p", point{T: 1, v: 2})
+	require.Nil(t, err, err)
+	res, err := m.ParseAndEval("p.T + p.v")
+	require.Nil(t, err, err)
+	require.EqualValues(t, 3, res.Value)
+
+	// unexported fields cannot be set, so they stay zero
+	val := node.NodeToValue()
+	require.EqualValues(t, 1, val.Field(0).Float())
+	require.EqualValues(t, 0, val.Field(1).Float())
+
+	// types that refer to themselves use any inside themselves, and cycles
+	// are cut
+	res, err = m.ParseAndEval(`func() {
+		type List struct { V int; Next *List }
+		type Tree struct { Kids []Tree }
+		l := &List{V: 1}
+		l.Next = &List{V: 2, Next: l}
+		_ = fmt.Errorf("%v %v", l, l.Next)
+		return l, Tree{[]Tree{{}}}
+	}()`)
+	require.Nil(t, err, err)
+	val = res.Elems[0].NodeToValue().Elem()
+	require.EqualValues(t, 1, val.Field(0).Int())
+	next := val.Field(1).Elem().Elem()
+	require.EqualValues(t, 2, next.Field(0).Int())
+	require.True(t, next.Field(1).IsNil())
+	val = res.Elems[1].NodeToValue()
+	require.EqualValues(t, 1, val.Field(0).Len())
+}
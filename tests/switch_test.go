@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSwitch tests switch statements with and without tags
+func TestSwitch(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		classify := func(n int) {
+			switch n {
+			case 0:
+				return 10
+			case 1, 2, 3:
+				return 20
+			default:
+				return 30
+			}
+		}
+		return classify(0), classify(2), classify(5)
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 10, res.Elems[0].Value)
+	require.EqualValues(t, 20, res.Elems[1].Value)
+	require.EqualValues(t, 30, res.Elems[2].Value)
+
+	// tagless switches and default clauses that are not last
+	stmt = `func() {
+		sign := func(n float64) {
+			switch {
+			default:
+				return 0
+			case n < 0:
+				return -1
+			case n > 0:
+				return 1
+			}
+		}
+		return sign(-2.5), sign(0), sign(3)
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, -1, res.Elems[0].Value)
+	require.EqualValues(t, 0, res.Elems[1].Value)
+	require.EqualValues(t, 1, res.Elems[2].Value)
+
+	// no matching clause does nothing
+	stmt = `func() {
+		x := 1
+		switch x {
+		case 2:
+			x = 3
+		}
+		return x
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Value)
+}
+
+// TestSwitchScope tests that the init statement and clauses get their own
+// scopes
+func TestSwitchScope(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		x := 1
+		switch x := 5; x {
+		case 5:
+			x := 6
+			x = x + 1
+		}
+		return x
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1, res.Value)
+
+	stmt = `func() {
+		switch y := 2; {
+		case y > 1:
+		}
+		return y
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
+
+// TestSwitchFallthrough tests fallthrough and break inside switches
+func TestSwitchFallthrough(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		sum := 0
+		switch 1 {
+		case 1:
+			sum += 1
+			fallthrough
+		case 2:
+			sum += 2
+			fallthrough
+		case 3:
+			sum += 4
+		case 4:
+			sum += 8
+		}
+		return sum
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 7, res.Value)
+
+	// break leaves the switch but not the enclosing loop
+	stmt = `func() {
+		sum := 0
+		for i := 0; i < 4; i++ {
+			switch i {
+			case 1:
+				break
+				sum += 100
+			}
+			sum += i
+		}
+		return sum
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 6, res.Value)
+
+	stmt = `func() {
+		switch 1 {
+		case 1:
+			fallthrough
+		}
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
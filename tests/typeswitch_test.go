@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/podocarp/goscript/types"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTypeAssert tests x.(T) with and without the comma-ok form
+func TestTypeAssert(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		var x any = 1.5
+		f := x.(float64)
+		s, ok := x.(string)
+		a, ok2 := x.(any)
+		return f, s, ok, a, ok2
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1.5, res.Elems[0].Value)
+	require.EqualValues(t, "", res.Elems[1].Value)
+	require.EqualValues(t, false, res.Elems[2].Value)
+	require.EqualValues(t, 1.5, res.Elems[3].Value)
+	require.EqualValues(t, true, res.Elems[4].Value)
+
+	// failed assertions without ok are errors
+	stmt = `func() {
+		var x interface{} = "a"
+		return x.(int)
+	}()
+	`
+	_, err = m.ParseAndEval(stmt)
+	require.NotNil(t, err)
+}
+
+// TestTypeSwitch tests switching on the type of values from the host
+func TestTypeSwitch(t *testing.T) {
+	m := machine.NewMachine()
+	err := m.AddToGlobalContext("inputs", []any{1, 2.5, "abc", []int{1, 2, 3}, nil})
+	require.Nil(t, err, err)
+
+	stmt := `func() {
+		describe := func(x any) {
+			switch v := x.(type) {
+			case int:
+				return v * 10
+			case float64:
+				return v * 100
+			case string, bool:
+				return len(v)
+			case nil:
+				return -1
+			default:
+				return -2
+			}
+		}
+
+		res := []any{}
+		for _, in := range inputs {
+			res = append(res, describe(in))
+		}
+		return res
+	}()
+	`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, []any{10, 250.0, 3, -2, -1},
+		res.NodeToValue().Interface())
+
+	// the guard does not need to bind a variable
+	stmt = `func() {
+		var x any = []int{1}
+		switch x.(type) {
+		case []float64:
+			return 1
+		case []int:
+			return 2
+		}
+		return 3
+	}()
+	`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 2, res.Value)
+}
+
+// TestAnyToNode tests that interfaces are converted to and from go values
+func TestAnyToNode(t *testing.T) {
+	node, err := machine.ValueToNode(map[string]any{"a": 1, "b": "x", "c": nil})
+	require.Nil(t, err, err)
+	require.True(t, node.Type.Equal(types.MapOf(types.StringType, types.AnyType)), node.Type)
+
+	val := node.NodeToValue().Interface()
+	require.EqualValues(t, map[string]any{"a": 1, "b": "x", "c": nil}, val)
+}
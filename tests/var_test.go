@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/podocarp/goscript/machine"
+	"github.com/stretchr/testify/require"
+)
+
+// TestVarZeroValues tests that variables declared without a value start at the
+// zero value of their type
+func TestVarZeroValues(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		type Point struct { X, Y float64; Name string }
+		var total float64
+		var n int
+		var u uint8
+		var s string
+		var ok bool
+		var arr []int
+		var p Point
+		return total, n, u, s, ok, len(arr), p.X, p.Name
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	expected := []any{0.0, int64(0), uint64(0), "", false, int64(0), 0.0, ""}
+	for i, val := range expected {
+		require.EqualValues(t, val, res.Elems[i].Value, i)
+	}
+
+	// reference types start out nil
+	stmt = `func() {
+		var arr []float64
+		var dict map[string]int
+		var ptr *int
+		arr = append(arr, 1.5)
+		return arr[0], len(dict), dict["a"], ptr
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, 1.5, res.Elems[0].Value)
+	require.EqualValues(t, 0, res.Elems[1].Value)
+	require.EqualValues(t, 0, res.Elems[2].Value)
+	require.Nil(t, res.Elems[3].Value)
+
+	// make fills arrays with zero values
+	stmt = `func() {
+		c := make([]float64, 3)
+		c[1] = c[0] + 2
+		s := make([]string, 1, 4)
+		s = s[:3]
+		return c, s[2] + "x"
+	}()`
+	res, err = m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	require.EqualValues(t, []float64{0, 2, 0}, res.Elems[0].NodeToValue().Interface())
+	require.EqualValues(t, "x", res.Elems[1].Value)
+}
+
+// TestVarDecl tests the different forms of var declarations
+func TestVarDecl(t *testing.T) {
+	m := machine.NewMachine()
+
+	stmt := `func() {
+		var (
+			a, b int = 1, 2
+			c    = "c"
+			d    float64 = 3
+			e    uint16
+		)
+		var f, g = func() (int, string) { return 4, "g" }()
+		return a + b, c, d, e, f, g
+	}()`
+	res, err := m.ParseAndEval(stmt)
+	require.Nil(t, err, err)
+	expected := []any{int64(3), "c", 3.0, uint64(0), int64(4), "g"}
+	for i, val := range expected {
+		require.EqualValues(t, val, res.Elems[i].Value, i)
+	}
+	require.EqualValues(t, "uint16", res.Elems[3].Type.String())
+
+	failingStmts := []string{
+		`func() { var a int = "a" }()`,
+		`func() { var a float64 = "a" }()`,
+		`func() { var a int = 1.5 }()`,
+		`func() { var a, b int = 1 }()`,
+		`func() { var a, b = 1, 2, 3 }()`,
+		`func() { var a, b = func() int { return 1 }() }()`,
+		`func() { var f func(); f() }()`,
+	}
+	for _, stmt := range failingStmts {
+		_, err := m.ParseAndEval(stmt)
+		require.NotNil(t, err, stmt)
+	}
+
+	_, err = m.ParseAndEval(`func() { var x, y = 1 }()`)
+	require.ErrorContains(t, err, "assignment mismatch: 2 variables but 1 values")
+}
@@ -0,0 +1,109 @@
+package types
+
+import (
+	"slices"
+
+	"github.com/go-errors/errors"
+)
+
+// Term is an element of a union in a type constraint, like the ~int in
+// ~int | float64.
+type Term struct {
+	Type Type
+	// Whether the term includes every type whose underlying type is Type
+	Tilde bool
+}
+
+func (t Term) String() string {
+	if t.Tilde {
+		return "~" + t.Type.String()
+	}
+	return t.Type.String()
+}
+
+// matches reports whether the type t is one of the types of the term.
+func (t Term) matches(other Type) bool {
+	if t.Tilde {
+		return other.Underlying().Equal(t.Type.Underlying())
+	}
+	return other.Equal(t.Type)
+}
+
+// UnionOf returns a constraint that is satisfied by the types of any of the
+// terms.
+func UnionOf(terms []Term) *_type {
+	return &_type{
+		kind:  Interface,
+		terms: terms,
+	}
+}
+
+// intersectTerms returns the terms for the types that are in both a and b.
+func intersectTerms(a, b []Term) []Term {
+	res := []Term{}
+	for _, x := range a {
+		for _, y := range b {
+			switch {
+			case y.matches(x.Type) && (!x.Tilde || y.Tilde):
+				res = append(res, x)
+			case x.matches(y.Type):
+				res = append(res, y)
+			}
+		}
+	}
+	return res
+}
+
+// IsConstraint reports whether t is an interface that can only be used as a
+// type constraint, because it has a union or is comparable.
+func IsConstraint(t Type) bool {
+	u, ok := t.Underlying().(*_type)
+	return ok && u.kind == Interface && (u.terms != nil || u.comparable)
+}
+
+// Satisfies checks that the type t satisfies the type constraint c.
+func Satisfies(t, c Type) error {
+	u := c.Underlying().(*_type)
+	if u.terms != nil && !slices.ContainsFunc(u.terms, func(term Term) bool {
+		return term.matches(t)
+	}) {
+		return errors.Errorf("%v does not satisfy %v", t, c)
+	}
+	if u.comparable && !Comparable(t) {
+		return errors.Errorf("%v does not satisfy comparable", t)
+	}
+
+	return Implements(t, c)
+}
+
+// Comparable reports whether values of type t can be compared with ==.
+func Comparable(t Type) bool {
+	switch t.Kind() {
+	case Array, Map, Func, Builtin:
+		return false
+	case Struct:
+		fields, _ := t.Fields()
+		for _, field := range fields {
+			if !Comparable(field.Type) {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
+
+// Union returns the constraint that is satisfied by the types of either x or
+// y, which must both be unions.
+func Union(x, y Type) (*_type, error) {
+	var terms []Term
+	for _, t := range []Type{x, y} {
+		u := t.Underlying().(*_type)
+		if u.kind != Interface || u.terms == nil || len(u.methods) != 0 || u.comparable {
+			return nil, errors.Errorf("cannot use %v in union", t)
+		}
+		terms = append(terms, u.terms...)
+	}
+	return UnionOf(terms), nil
+}
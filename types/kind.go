@@ -17,8 +17,17 @@ const (
 	Bool
 
 	Array
+	Map
+	Struct
+	Pointer
+	Chan
+	// Errors that hold a go error, like the ones made by errors.New
+	Error
+	Interface
 	Func
 	Builtin
+	// The type of a type name, like the Point in type Point struct{}
+	TypeName
 	// Used for multiple value statements, like a, b := f()
 	Packing
 )
@@ -31,10 +40,17 @@ var kindStr = []string{
 	Uint:    "uint",
 	Bool:    "bool",
 
-	Array:   "array",
-	Func:    "function",
-	Builtin: "builtin",
-	Packing: "packing",
+	Array:     "array",
+	Map:       "map",
+	Struct:    "struct",
+	Pointer:   "pointer",
+	Chan:      "chan",
+	Error:     "error",
+	Interface: "interface",
+	Func:      "function",
+	Builtin:   "builtin",
+	TypeName:  "typename",
+	Packing:   "packing",
 }
 
 func (k Kind) String() string {
@@ -54,8 +70,23 @@ func ReflectKindToKind(r reflect.Kind) (Kind, error) {
 	case reflect.Int, reflect.Int8, reflect.Int16,
 		reflect.Int32, reflect.Int64:
 		return Int, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64:
+		return Uint, nil
+	case reflect.Bool:
+		return Bool, nil
 	case reflect.Array, reflect.Slice:
 		return Array, nil
+	case reflect.Map:
+		return Map, nil
+	case reflect.Struct:
+		return Struct, nil
+	case reflect.Pointer:
+		return Pointer, nil
+	case reflect.Chan:
+		return Chan, nil
+	case reflect.Interface:
+		return Interface, nil
 	default:
 		return Invalid, errors.Errorf("unsupported reflect.Kind %s", r)
 	}
@@ -1,7 +1,11 @@
 package types
 
 import (
+	"go/token"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/go-errors/errors"
 )
@@ -12,10 +16,35 @@ var (
 	FloatType       = LiteralOf(Float)
 	IntType         = LiteralOf(Int)
 	UintType        = LiteralOf(Uint)
+	Int8Type        = sizedOf(Int, 8)
+	Int16Type       = sizedOf(Int, 16)
+	Int32Type       = sizedOf(Int, 32)
+	Int64Type       = sizedOf(Int, 64)
+	Uint8Type       = sizedOf(Uint, 8)
+	Uint16Type      = sizedOf(Uint, 16)
+	Uint32Type      = sizedOf(Uint, 32)
+	Uint64Type      = sizedOf(Uint, 64)
 	BoolType        = LiteralOf(Bool)
+	// The empty interface, which every value satisfies
+	AnyType = LiteralOf(Interface)
+	// The constraint satisfied by types that can be compared with ==
+	ComparableType = declaredOf("comparable", &_type{
+		kind:       Interface,
+		comparable: true,
+	})
+	// The error interface
+	ErrorType = declaredOf("error", InterfaceOf([]Method{{Name: "Error", Results: 1}}, nil))
+	// The type of errors made by errors.New and of errors passed in by the
+	// host, whose values are go errors
+	GoErrorType = &_type{
+		kind:    Error,
+		methods: []Method{{Name: "Error", Results: 1}},
+	}
 	// TODO: func should contain parameter's types
 	FuncType    = LiteralOf(Func)
 	BuiltinType = LiteralOf(Builtin)
+	// The type of nodes that hold a type, like the Point in Point{1, 2}
+	TypeNameType = LiteralOf(TypeName)
 
 	// The so called idiom on go/reflect's pkg.go.dev page:
 	// reflect.TypeOf((*string)(nil)).Elem()
@@ -23,27 +52,107 @@ var (
 	// literals
 	stringReflectType = reflect.TypeOf("")
 	floatReflectType  = reflect.TypeOf(float64(0))
-	intReflectType    = reflect.TypeOf(int64(0))
-	uintReflectType   = reflect.TypeOf(uint64(0))
+	intReflectType    = reflect.TypeOf(0)
+	uintReflectType   = reflect.TypeOf(uint(0))
+	boolReflectType   = reflect.TypeOf(false)
+	anyReflectType    = reflect.TypeOf((*any)(nil)).Elem()
+	errorReflectType  = reflect.TypeOf((*error)(nil)).Elem()
+
+	sizedReflectTypes = map[Kind]map[int]reflect.Type{
+		Int: {
+			8:  reflect.TypeOf(int8(0)),
+			16: reflect.TypeOf(int16(0)),
+			32: reflect.TypeOf(int32(0)),
+			64: reflect.TypeOf(int64(0)),
+		},
+		Uint: {
+			8:  reflect.TypeOf(uint8(0)),
+			16: reflect.TypeOf(uint16(0)),
+			32: reflect.TypeOf(uint32(0)),
+			64: reflect.TypeOf(uint64(0)),
+		},
+	}
 )
 
+// Package path given to unexported fields of structs converted to a
+// reflect.Type. reflect.StructOf refuses unexported fields without one.
+const scriptPkgPath = "goscript"
+
 type Type interface {
 	// Kind returns the underlying Kind of this type
 	Kind() Kind
 
 	// Elem returns a type's element type.
-	// The type's Kind must be Array.
+	// The type's Kind must be Array, Chan, Map or Pointer.
 	Elem() (Type, error)
+	// Key returns a map type's key type.
+	// The type's Kind must be Map.
+	Key() (Type, error)
+	// Fields returns a struct type's fields.
+	// The type's Kind must be Struct.
+	Fields() ([]Field, error)
+	// Methods returns the methods declared on a named type, or the methods
+	// an interface type requires, sorted by name.
+	Methods() []Method
+	// AddMethod declares a method on a named type.
+	AddMethod(Method) error
 	Equal(Type) bool
 
+	// Name returns the name of a declared type, or "" for type literals.
+	Name() string
+	// Bits returns the size of an integer type in bits. The int and uint
+	// types are 64 bits wide. It returns 0 for non-integer types.
+	Bits() int
+	// Underlying returns the type a declared type is defined with. For type
+	// literals it returns the type itself.
+	Underlying() Type
+
 	String() string
 	TypeToReflectType() reflect.Type
 }
 
+// Field describes a single field of a struct type.
+type Field struct {
+	Name string
+	Type Type
+}
+
+// Method describes a method of a named type or an interface type. Only the
+// names of methods are checked, not their signatures.
+type Method struct {
+	Name string
+	// Whether the method has a pointer receiver, like func (p *Point) M().
+	// Never set for the methods of interfaces.
+	PointerRecv bool
+	// The number of parameters and results, and whether the last parameter
+	// is variadic. The types of parameters and results are not checked.
+	Params, Results int
+	Variadic        bool
+}
+
+// sameSignature reports whether two methods take and return the same number of
+// values.
+func (m Method) sameSignature(o Method) bool {
+	return m.Params == o.Params && m.Results == o.Results && m.Variadic == o.Variadic
+}
+
 type _type struct {
-	kind  Kind
-	elt   Type
-	islit bool
+	kind    Kind
+	elt     Type
+	key     Type
+	fields  []Field
+	methods []Method
+	// for interfaces used as constraints, the types that satisfy the
+	// constraint. nil means that any type does.
+	terms      []Term
+	comparable bool
+	islit      bool
+	// size of sized integer types like int8, 0 for int and uint
+	bits int
+
+	// only set for declared types
+	name       string
+	underlying *_type
 }
 
 func LiteralOf(kind Kind) *_type {
@@ -52,6 +161,14 @@ func LiteralOf(kind Kind) *_type {
 	}
 }
 
+// sizedOf returns an integer type of the given size, like int8.
+func sizedOf(kind Kind, bits int) *_type {
+	return &_type{
+		kind: kind,
+		bits: bits,
+	}
+}
+
 func ArrayOf(elemType Type) *_type {
 	return &_type{
 		kind: Array,
@@ -59,83 +176,474 @@ func ArrayOf(elemType Type) *_type {
 	}
 }
 
+func MapOf(keyType, elemType Type) *_type {
+	return &_type{
+		kind: Map,
+		key:  keyType,
+		elt:  elemType,
+	}
+}
+
+func PointerOf(elemType Type) *_type {
+	return &_type{
+		kind: Pointer,
+		elt:  elemType,
+	}
+}
+
+// ChanOf returns a channel type. Channel directions are not tracked, all
+// channels can be both sent to and received from.
+func ChanOf(elemType Type) *_type {
+	return &_type{
+		kind: Chan,
+		elt:  elemType,
+	}
+}
+
+func StructOf(fields []Field) *_type {
+	return &_type{
+		kind:   Struct,
+		fields: fields,
+	}
+}
+
+// InterfaceOf returns an interface type that requires the given methods, and
+// everything that the embedded interfaces require.
+func InterfaceOf(methods []Method, embedded []Type) *_type {
+	t := &_type{
+		kind:    Interface,
+		methods: slices.Clone(methods),
+	}
+	for _, e := range embedded {
+		e := e.Underlying().(*_type)
+		for _, method := range e.methods {
+			if !slices.ContainsFunc(t.methods, func(m Method) bool {
+				return m.Name == method.Name
+			}) {
+				t.methods = append(t.methods, method)
+			}
+		}
+		t.comparable = t.comparable || e.comparable
+		switch {
+		case e.terms == nil:
+		case t.terms == nil:
+			t.terms = e.terms
+		default:
+			t.terms = intersectTerms(t.terms, e.terms)
+		}
+	}
+
+	slices.SortFunc(t.methods, func(a, b Method) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return t
+}
+
+// NamedOf creates a declared type with the given name. The type is not usable
+// until SetUnderlying is called. The two steps are separate so that a type can
+// refer to itself, like in type List struct { next *List }.
+func NamedOf(name string) *_type {
+	return &_type{
+		name: name,
+	}
+}
+
+// declaredOf returns a declared type that is ready to use.
+func declaredOf(name string, underlying Type) *_type {
+	t := NamedOf(name)
+	t.SetUnderlying(underlying)
+	return t
+}
+
+// SetUnderlying sets the type that a declared type is defined with.
+func (t *_type) SetUnderlying(u Type) {
+	under := u.Underlying().(*_type)
+	t.kind = under.kind
+	t.elt = under.elt
+	t.key = under.key
+	t.fields = under.fields
+	t.bits = under.bits
+	if under.kind == Interface {
+		// declared types do not get the methods of the type they are
+		// defined with, except for interfaces whose methods are the
+		// type
+		t.methods = under.methods
+		t.terms = under.terms
+		t.comparable = under.comparable
+	}
+	t.underlying = under
+}
+
 func (t *_type) Kind() Kind {
 	return t.kind
 }
 
 func (t *_type) Elem() (Type, error) {
-	if t.kind != Array {
+	if t.kind != Array && t.kind != Chan && t.kind != Map && t.kind != Pointer {
 		return nil, errors.New("cannot call Elem for non-array type")
 	}
 
 	return t.elt, nil
 }
 
+func (t *_type) Key() (Type, error) {
+	if t.kind != Map {
+		return nil, errors.New("cannot call Key for non-map type")
+	}
+
+	return t.key, nil
+}
+
+func (t *_type) Fields() ([]Field, error) {
+	if t.kind != Struct {
+		return nil, errors.New("cannot call Fields for non-struct type")
+	}
+
+	return t.fields, nil
+}
+
+func (t *_type) Methods() []Method {
+	return t.methods
+}
+
+func (t *_type) AddMethod(method Method) error {
+	switch {
+	case t.name == "":
+		return errors.Errorf("cannot define methods on unnamed type %v", t)
+	case t.kind == Pointer || t.kind == Interface:
+		return errors.Errorf("invalid receiver type %v", t)
+	}
+	for _, field := range t.fields {
+		if field.Name == method.Name {
+			return errors.Errorf("field and method with the same name %s", method.Name)
+		}
+	}
+
+	i, found := slices.BinarySearchFunc(t.methods, method.Name, func(m Method, name string) int {
+		return strings.Compare(m.Name, name)
+	})
+	if found {
+		return errors.Errorf("method %v.%s already declared", t, method.Name)
+	}
+	t.methods = slices.Insert(t.methods, i, method)
+	return nil
+}
+
+func (t *_type) Name() string {
+	return t.name
+}
+
+func (t *_type) Bits() int {
+	if t.kind != Int && t.kind != Uint {
+		return 0
+	}
+	if t.bits == 0 {
+		return 64
+	}
+
+	return t.bits
+}
+
+func (t *_type) Underlying() Type {
+	if t.underlying != nil {
+		return t.underlying
+	}
+
+	return t
+}
+
 func (t *_type) String() string {
+	if t.name != "" {
+		return t.name
+	}
+
 	switch t.kind {
 	case Array:
 		return "[]" + t.elt.String()
+	case Map:
+		return "map[" + t.key.String() + "]" + t.elt.String()
+	case Pointer:
+		return "*" + t.elt.String()
+	case Chan:
+		return "chan " + t.elt.String()
+	case Error:
+		// what errors.New makes, which most of these are
+		return "*errors.errorString"
+	case Interface:
+		var elems []string
+		for _, method := range t.methods {
+			elems = append(elems, method.Name)
+		}
+		if t.terms != nil {
+			terms := make([]string, len(t.terms))
+			for i, term := range t.terms {
+				terms[i] = term.String()
+			}
+			if len(elems) == 0 && !t.comparable {
+				// a plain union like int | float64
+				return strings.Join(terms, " | ")
+			}
+			elems = append(elems, strings.Join(terms, " | "))
+		}
+		if t.comparable {
+			elems = append(elems, "comparable")
+		}
+		if len(elems) == 0 {
+			return "interface {}"
+		}
+		return "interface { " + strings.Join(elems, "; ") + " }"
+	case Int, Uint:
+		if t.bits != 0 {
+			return t.kind.String() + strconv.Itoa(t.bits)
+		}
+		return t.kind.String()
+	case Struct:
+		fields := make([]string, len(t.fields))
+		for i, field := range t.fields {
+			fields[i] = field.Name + " " + field.Type.String()
+		}
+		return "struct{" + strings.Join(fields, "; ") + "}"
 	default:
 		return t.kind.String()
 	}
 }
 
 func (t *_type) Equal(other Type) bool {
+	// declared types are only ever equal to themselves
+	if t.name != "" || other.Name() != "" {
+		return Type(t) == other
+	}
+
 	if t.Kind() != other.Kind() {
 		return false
 	}
 
-	if t.Kind() == Array {
+	switch t.Kind() {
+	case Int, Uint:
+		// int and int64 are different types even though they have the
+		// same size
+		o, ok := other.(*_type)
+		return ok && t.bits == o.bits
+	case Array, Chan, Pointer:
 		otherElem, _ := other.Elem()
 		return t.elt.Equal(otherElem)
-	} else {
+	case Map:
+		otherKey, _ := other.Key()
+		otherElem, _ := other.Elem()
+		return t.key.Equal(otherKey) && t.elt.Equal(otherElem)
+	case Interface:
+		o, ok := other.(*_type)
+		if !ok || t.comparable != o.comparable ||
+			len(t.methods) != len(o.methods) ||
+			(t.terms == nil) != (o.terms == nil) ||
+			len(t.terms) != len(o.terms) {
+			return false
+		}
+		for i, method := range t.methods {
+			if method.Name != o.methods[i].Name || !method.sameSignature(o.methods[i]) {
+				return false
+			}
+		}
+		for i, term := range t.terms {
+			if term.Tilde != o.terms[i].Tilde || !term.Type.Equal(o.terms[i].Type) {
+				return false
+			}
+		}
+		return true
+	case Struct:
+		otherFields, _ := other.Fields()
+		if len(t.fields) != len(otherFields) {
+			return false
+		}
+		for i, field := range t.fields {
+			if field.Name != otherFields[i].Name ||
+				!field.Type.Equal(otherFields[i].Type) {
+				return false
+			}
+		}
+		return true
+	default:
 		return true
 	}
 }
 
 func (t *_type) TypeToReflectType() reflect.Type {
+	return t.reflectType(map[*_type]reflect.Type{})
+}
+
+// reflectType converts t to a reflect.Type. Declared types that are already
+// converted are kept in cache, and are nil there while they are still being
+// converted. reflect cannot make types that refer to themselves, so a declared
+// type used inside itself, like the next in type List struct { next *List },
+// becomes any.
+func (t *_type) reflectType(cache map[*_type]reflect.Type) reflect.Type {
+	if t.name != "" {
+		r, ok := cache[t]
+		if ok && r == nil {
+			return anyReflectType
+		}
+		if ok {
+			return r
+		}
+		cache[t] = nil
+		r = t.convertReflectType(cache)
+		cache[t] = r
+		return r
+	}
+	return t.convertReflectType(cache)
+}
+
+func (t *_type) convertReflectType(cache map[*_type]reflect.Type) reflect.Type {
 	switch t.kind {
 	case Array:
-		elementType := t.elt.TypeToReflectType()
+		elementType := t.elt.(*_type).reflectType(cache)
 		return reflect.SliceOf(elementType)
+	case Pointer:
+		return reflect.PointerTo(t.elt.(*_type).reflectType(cache))
+	case Chan:
+		return reflect.ChanOf(reflect.BothDir, t.elt.(*_type).reflectType(cache))
+	case Map:
+		return reflect.MapOf(
+			t.key.(*_type).reflectType(cache),
+			t.elt.(*_type).reflectType(cache),
+		)
+	case Struct:
+		fields := make([]reflect.StructField, len(t.fields))
+		for i, field := range t.fields {
+			fields[i] = reflect.StructField{
+				Name: field.Name,
+				Type: field.Type.(*_type).reflectType(cache),
+			}
+			if !token.IsExported(field.Name) {
+				fields[i].PkgPath = scriptPkgPath
+			}
+		}
+		return reflect.StructOf(fields)
+	case Bool:
+		return boolReflectType
 	case Float:
 		return floatReflectType
 	case Func:
 		return reflect.FuncOf([]reflect.Type{}, []reflect.Type{}, false)
+	case Interface:
+		if len(t.methods) == 1 && t.methods[0].Name == "Error" {
+			return errorReflectType
+		}
+		return anyReflectType
+	case Error:
+		return errorReflectType
 	case Int:
+		if t.bits != 0 {
+			return sizedReflectTypes[Int][t.bits]
+		}
 		return intReflectType
 	case String:
 		return stringReflectType
 	case Uint:
-		return intReflectType
+		if t.bits != 0 {
+			return sizedReflectTypes[Uint][t.bits]
+		}
+		return uintReflectType
 	default:
 		return nil
 	}
 }
 
 func ReflectTypeToType(r reflect.Type) (*_type, error) {
-	if r.Kind() != reflect.Array && r.Kind() != reflect.Slice {
-		// new literal of the same type
-		kind, err := ReflectKindToKind(r.Kind())
+	switch r.Kind() {
+	case reflect.Array, reflect.Slice:
+		elemType, err := ReflectTypeToType(r.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return ArrayOf(elemType), nil
+	case reflect.Map:
+		keyType, err := ReflectTypeToType(r.Key())
 		if err != nil {
 			return nil, err
 		}
-		return LiteralOf(kind), nil
-	}
-
-	if r.Elem().Kind() == reflect.Array || r.Elem().Kind() == reflect.Slice {
-		// array of array
 		elemType, err := ReflectTypeToType(r.Elem())
 		if err != nil {
 			return nil, err
 		}
-		return ArrayOf(elemType), nil
-	} else {
-		// new array of the same type
-		kind, err := ReflectKindToKind(r.Elem().Kind())
+		return MapOf(keyType, elemType), nil
+	case reflect.Pointer:
+		elemType, err := ReflectTypeToType(r.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return PointerOf(elemType), nil
+	case reflect.Struct:
+		fields := make([]Field, r.NumField())
+		for i := range fields {
+			fieldType, err := ReflectTypeToType(r.Field(i).Type)
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = Field{
+				Name: r.Field(i).Name,
+				Type: fieldType,
+			}
+		}
+		return StructOf(fields), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		kind, err := ReflectKindToKind(r.Kind())
 		if err != nil {
 			return nil, err
 		}
-		return ArrayOf(LiteralOf(kind)), nil
+		return sizedOf(kind, r.Bits()), nil
+	default:
+		// new literal of the same type
+		kind, err := ReflectKindToKind(r.Kind())
+		if err != nil {
+			return nil, err
+		}
+		return LiteralOf(kind), nil
+	}
+}
+
+// Implements checks that values of type t can be stored in the interface type
+// iface, which is when t has all the methods that iface requires, with the
+// same number of parameters and results. Only pointers have the methods with
+// pointer receivers.
+func Implements(t, iface Type) error {
+	methods := t.Methods()
+	isPtr := t.Kind() == Pointer && t.Name() == ""
+	if isPtr {
+		elem, _ := t.Elem()
+		methods = elem.Methods()
+	}
+
+	for _, want := range iface.Methods() {
+		i := slices.IndexFunc(methods, func(m Method) bool {
+			return m.Name == want.Name
+		})
+		if i < 0 {
+			return errors.Errorf(
+				"%v does not implement %v (missing method %s)",
+				t,
+				iface,
+				want.Name,
+			)
+		}
+		if !methods[i].sameSignature(want) {
+			return errors.Errorf(
+				"%v does not implement %v (wrong type for method %s)",
+				t,
+				iface,
+				want.Name,
+			)
+		}
+		if methods[i].PointerRecv && !isPtr {
+			return errors.Errorf(
+				"%v does not implement %v (method %s has pointer receiver)",
+				t,
+				iface,
+				want.Name,
+			)
+		}
 	}
+
+	return nil
 }